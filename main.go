@@ -23,32 +23,62 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
+	"fmt"
+	"io"
+	"log/slog"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/norio-nomura/cli_discord_bot2/pkg/client"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/irc"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/logging"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/message"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/metrics"
 	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/server"
 )
 
+// shutdownGracePeriod is the time allowed after the drain timeout for aborted jobs to reply and the client to close.
+const shutdownGracePeriod = 15 * time.Second
+
 func main() {
 	var (
 		debug                bool
 		readOptionsFromStdin bool
+		checkConfig          bool
+		printConfig          bool
+		local                bool
 		opt                  *options.Options
 	)
-	flag.BoolVar(&debug, "debug", false, "Enable debug mode")
+	flag.BoolVar(&debug, "debug", false, "Enable debug mode and debug logging")
 	flag.BoolVar(&readOptionsFromStdin, "stdin", false, "Read JSON from stdin")
+	flag.BoolVar(&checkConfig, "check-config", false, "Validate the configuration and exit")
+	flag.BoolVar(&printConfig, "print-config", false, "Print the effective configuration with secrets redacted and exit")
+	flag.BoolVar(&local, "local", false, "Execute a message body read from the file argument or stdin without Discord and print the replies")
 	flag.Parse()
+	if local {
+		os.Exit(runLocal(flag.Arg(0), debug))
+	}
 	if readOptionsFromStdin {
 		optFromStdin, err := options.FromStdin()
+		if checkConfig || printConfig {
+			os.Exit(reportConfig(optFromStdin, err, checkConfig, printConfig))
+		}
 		if err != nil {
 			panic(err)
 		}
 		opt = optFromStdin
 	} else {
 		optFromEnv, err := options.FromEnv()
+		if checkConfig || printConfig {
+			os.Exit(reportConfig(optFromEnv, err, checkConfig, printConfig))
+		}
 		if err != nil {
 			panic(err)
 		}
@@ -60,15 +90,179 @@ func main() {
 			panic(err)
 		}
 	}
+	level := slog.Leveler(opt.LogLevel)
+	if debug {
+		level = slog.LevelDebug
+	}
+	logger, err := logging.New(os.Stderr, level, opt.LogFormat)
+	if err != nil {
+		panic(err)
+	}
+	slog.SetDefault(logger)
 	bot, err := client.New(opt)
 	if err != nil {
 		panic(err)
 	}
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
 	defer stop()
-	defer bot.Close(ctx)
-	if err := bot.OpenGateway(ctx); err != nil {
+	// Keep serving HTTP during shutdown so that readiness reports draining.
+	serveCtx, stopServing := context.WithCancel(context.Background())
+	defer stopServing()
+	if opt.HTTPListenAddress != "" {
+		go serveHTTP(serveCtx, opt, bot)
+	}
+	if err := client.Open(ctx, bot); err != nil {
 		panic(err)
 	}
+	var ircClient *irc.Client
+	if opt.IRC.Server != "" {
+		ircClient = irc.New(opt, future.WithExecutor(bot.Executor()))
+		go ircClient.Serve(serveCtx)
+	}
 	<-ctx.Done()
+	// Restore the default signal behavior so that a second signal terminates immediately.
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), opt.DrainTimeout()+shutdownGracePeriod)
+	defer cancel()
+	ircDone := make(chan struct{})
+	go func() {
+		defer close(ircDone)
+		if ircClient != nil {
+			ircClient.Shutdown(shutdownCtx, opt.DrainTimeout())
+		}
+	}()
+	bot.Shutdown(shutdownCtx, opt.DrainTimeout())
+	bot.Close(shutdownCtx)
+	<-ircDone
+}
+
+// serveHTTP serves the health, readiness and metrics endpoints, and the execution API if enabled, until ctx is done.
+func serveHTTP(ctx context.Context, opt *options.Options, c *client.Bot) {
+	srv := server.New(opt.HTTPListenAddress)
+	srv.Handle("GET /metrics", metrics.Handler())
+	if opt.HTTPAPIToken != "" {
+		srv.Handle("POST /v1/execute", c.ExecuteHandler(opt.HTTPAPIToken))
+	}
+	metrics.SetShardStatusFunc(func() map[int]string {
+		statuses := make(map[int]string)
+		for id, status := range client.ShardStatuses(c) {
+			statuses[id] = status.String()
+		}
+		return statuses
+	})
+	srv.AddReadinessCheck("shutdown", c.Draining())
+	srv.AddReadinessCheck("gateway", client.GatewayReady(c))
+	srv.AddReadinessCheck("target", func(context.Context) error {
+		_, err := opt.LookPathTargetCLI()
+		return err
+	})
+	srv.AddReadinessCheck("executions", func(context.Context) error {
+		if e := c.Executor(); e.Saturated() {
+			return fmt.Errorf("execution queue is full: %d running, %d queued", e.Running(), e.Queued())
+		}
+		return nil
+	})
+	if err := srv.ListenAndServe(ctx); err != nil {
+		slog.Error("Failed to serve HTTP", slog.Any("err", err))
+	}
+}
+
+// reportConfig handles `--check-config` and `--print-config`.
+// It prints the effective configuration and/or every problem found, and returns the exit code.
+func reportConfig(opt *options.Options, loadErr error, checkConfig, printConfig bool) int {
+	if printConfig && opt != nil {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(opt.Redacted()); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to print configuration: %v\n", err)
+			return 1
+		}
+	}
+	err := loadErr
+	if checkConfig && opt != nil {
+		err = errors.Join(err, opt.CheckCommands())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return 1
+	}
+	if checkConfig {
+		fmt.Fprintln(os.Stderr, "configuration is valid")
+	}
+	return 0
+}
+
+// runLocal handles `--local`. It reads a message body from path, or from stdin if path is empty or "-",
+// executes it like a Discord message without a token, prints each reply with its attached files,
+// and returns the exit code.
+func runLocal(path string, debug bool) int {
+	opt, err := options.FromEnvWithoutToken()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid configuration:\n%v\n", err)
+		return 1
+	}
+	level := slog.Leveler(opt.LogLevel)
+	if debug {
+		level = slog.LevelDebug
+	}
+	logger, err := logging.New(os.Stderr, level, opt.LogFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	slog.SetDefault(logger)
+	content, err := readLocalMessage(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read message: %v\n", err)
+		return 1
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	code, replies := 0, 0
+	for result := range future.Await(ctx, message.ExecuteLocal(ctx, opt, string(content), future.WithExecutor(future.NewExecutor(opt.ConcurrentExecutions(), opt.ExecutionQueueSize)))) {
+		replies++
+		if result.Err != nil {
+			fmt.Fprintf(os.Stderr, "reply %d failed: %v\n", replies, result.Err)
+			code = 1
+			continue
+		}
+		if err := printLocalReply(os.Stdout, replies, result.Value); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to print reply %d: %v\n", replies, err)
+			code = 1
+		}
+	}
+	if replies == 0 {
+		fmt.Fprintln(os.Stderr, "no reply; mention the bot as `@bot`")
+	}
+	return code
+}
+
+// readLocalMessage reads the message body for `--local` from path, or from stdin if path is empty or "-".
+// On a terminal, it prompts for the message first.
+func readLocalMessage(path string) ([]byte, error) {
+	if path != "" && path != "-" {
+		return os.ReadFile(path)
+	}
+	if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		fmt.Fprintln(os.Stderr, "Enter a message mentioning the bot as `@bot`, then press Ctrl-D:")
+	}
+	return io.ReadAll(os.Stdin)
+}
+
+// printLocalReply prints the content of the n-th reply and the names and sizes of its attached files.
+func printLocalReply(w io.Writer, n int, r *message.ExecutionResult) error {
+	if _, err := fmt.Fprintf(w, "--- reply %d ---\n%s\n", n, r.Content); err != nil {
+		return err
+	}
+	for _, file := range r.Files {
+		size, err := io.Copy(io.Discard, file.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+		if _, err := fmt.Fprintf(w, "--- attached file: %s (%d bytes) ---\n", file.Name, size); err != nil {
+			return err
+		}
+	}
+	return nil
 }
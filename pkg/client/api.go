@@ -0,0 +1,206 @@
+package client
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/logging"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/message"
+)
+
+// maxExecuteRequestBytes limits the size of a request body of `POST /v1/execute`.
+const maxExecuteRequestBytes = 16 << 20
+
+// ErrDraining is returned for executions requested once shutdown has begun or aborted on shutdown.
+var ErrDraining = errors.New("shutting down")
+
+// apiFile is a file in a request or response of `POST /v1/execute`, with the content encoded in base64.
+type apiFile struct {
+	Name    string `json:"name"`
+	Content []byte `json:"content"`
+}
+
+// executeRequest is the JSON request body of `POST /v1/execute`.
+type executeRequest struct {
+	Args  []string  `json:"args"`
+	Stdin *string   `json:"stdin"`
+	Files []apiFile `json:"files"`
+}
+
+// executeResponse is the JSON response body of `POST /v1/execute`.
+type executeResponse struct {
+	Content string    `json:"content"`
+	Files   []apiFile `json:"files"`
+}
+
+// Execute executes the target CLI for a request from outside of Discord.
+// The execution is a job of the bot like a Discord message: it is counted as in flight, refused with
+// ErrDraining once shutdown has begun, and aborted with ErrDraining when the drain timeout expires.
+func (b *Bot) Execute(ctx context.Context, r message.ExecuteRequest) (*message.ExecutionResult, error) {
+	return b.handler.execute(ctx, r)
+}
+
+// ExecuteHandler returns the handler of `POST /v1/execute` authorized by the bearer token.
+// The request is a JSON object with `args`, `stdin` and `files` of `name` and base64 `content`,
+// or multipart/form-data with `args` values, a `stdin` value or file and `files` files.
+// The response is a JSON object with `content` and `files` in the same form, or multipart/form-data
+// with a `content` part and `files` parts if the request accepts it.
+func (b *Bot) ExecuteHandler(token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(auth), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAPIError(w, http.StatusUnauthorized, errors.New("invalid bearer token"))
+			return
+		}
+		r.Body = http.MaxBytesReader(w, r.Body, maxExecuteRequestBytes)
+		req, err := readExecuteRequest(r)
+		if err != nil {
+			writeAPIError(w, http.StatusBadRequest, err)
+			return
+		}
+		result, err := b.Execute(r.Context(), req)
+		switch {
+		case errors.Is(err, message.ErrInvalidArgs):
+			writeAPIError(w, http.StatusBadRequest, err)
+		case errors.Is(err, ErrDraining):
+			writeAPIError(w, http.StatusServiceUnavailable, err)
+		case err != nil:
+			writeAPIError(w, http.StatusInternalServerError, err)
+		default:
+			if err := writeExecuteResponse(w, r, result); err != nil {
+				slog.Error("Failed to write execution result", slog.Any("err", err))
+			}
+		}
+	})
+}
+
+// execute runs the request as a job of the handler, see Bot.Execute.
+func (q *messageEventsHandler) execute(ctx context.Context, r message.ExecuteRequest) (*message.ExecutionResult, error) {
+	q.mu.RLock()
+	if q.draining {
+		q.mu.RUnlock()
+		return nil, ErrDraining
+	}
+	q.jobs.Add(1)
+	q.mu.RUnlock()
+	defer q.jobs.Done()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(q.abortCtx, cancel)()
+	logger := slog.Default().With(slog.String("job.id", logging.NewJobID()))
+	logger.Debug("Processing execution request", slog.Any("args", r.Args))
+	result, err := message.Execute(logging.WithLogger(ctx, logger), q.options, r, future.WithExecutor(q.executor))
+	if q.abortCtx.Err() != nil {
+		return nil, ErrDraining
+	}
+	return result, err
+}
+
+// readExecuteRequest decodes the JSON or multipart/form-data request body of `POST /v1/execute`.
+func readExecuteRequest(r *http.Request) (message.ExecuteRequest, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		var body executeRequest
+		decoder := json.NewDecoder(r.Body)
+		decoder.DisallowUnknownFields()
+		if err := decoder.Decode(&body); err != nil {
+			return message.ExecuteRequest{}, fmt.Errorf("failed to decode JSON: %w", err)
+		}
+		req := message.ExecuteRequest{Args: body.Args}
+		if body.Stdin != nil {
+			req.Stdin = []byte(*body.Stdin)
+		}
+		for _, file := range body.Files {
+			req.Files = append(req.Files, message.InputFile{Name: file.Name, Content: file.Content})
+		}
+		return req, nil
+	}
+	if err := r.ParseMultipartForm(maxExecuteRequestBytes); err != nil {
+		return message.ExecuteRequest{}, fmt.Errorf("failed to parse multipart form: %w", err)
+	}
+	req := message.ExecuteRequest{Args: r.MultipartForm.Value["args"]}
+	if stdin := r.MultipartForm.Value["stdin"]; len(stdin) > 0 {
+		req.Stdin = []byte(stdin[0])
+	}
+	if headers := r.MultipartForm.File["stdin"]; len(headers) > 0 {
+		content, err := readFormFile(headers[0])
+		if err != nil {
+			return message.ExecuteRequest{}, err
+		}
+		req.Stdin = content
+	}
+	for _, header := range r.MultipartForm.File["files"] {
+		content, err := readFormFile(header)
+		if err != nil {
+			return message.ExecuteRequest{}, err
+		}
+		req.Files = append(req.Files, message.InputFile{Name: header.Filename, Content: content})
+	}
+	return req, nil
+}
+
+// readFormFile reads the content of a file of a multipart form.
+func readFormFile(header *multipart.FileHeader) ([]byte, error) {
+	f, err := header.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", header.Filename, err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// writeExecuteResponse writes the result as multipart/form-data if the request accepts it, otherwise as JSON.
+func writeExecuteResponse(w http.ResponseWriter, r *http.Request, result *message.ExecutionResult) error {
+	body := executeResponse{Content: result.Content, Files: make([]apiFile, 0, len(result.Files))}
+	for _, file := range result.Files {
+		content, err := io.ReadAll(file.Reader)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+		body.Files = append(body.Files, apiFile{Name: file.Name, Content: content})
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "multipart/form-data") {
+		w.Header().Set("Content-Type", "application/json")
+		return json.NewEncoder(w).Encode(body)
+	}
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", mw.FormDataContentType())
+	if err := mw.WriteField("content", body.Content); err != nil {
+		return err
+	}
+	for _, file := range body.Files {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Disposition", mime.FormatMediaType("form-data", map[string]string{"name": "files", "filename": file.Name}))
+		header.Set("Content-Type", "application/octet-stream")
+		part, err := mw.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err := part.Write(file.Content); err != nil {
+			return err
+		}
+	}
+	return mw.Close()
+}
+
+// writeAPIError writes the error as a JSON object with the given status code.
+func writeAPIError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(map[string]string{"error": err.Error()}); err != nil {
+		slog.Error("Failed to write HTTP response", slog.Any("err", err))
+	}
+}
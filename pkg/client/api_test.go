@@ -0,0 +1,93 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func postExecute(t *testing.T, h http.Handler, contentType, accept string, body io.Reader) *http.Response {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/v1/execute", body)
+	r.Header.Set("Authorization", "Bearer secret")
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w.Result()
+}
+
+func TestExecuteHandler(t *testing.T) {
+	b := &Bot{handler: newTestHandler(nil)}
+	b.handler.options.NumberOfLinesToEmbedOutput = 1
+	b.handler.options.NumberOfLinesToEmbedUploadedOutput = 1
+	h := b.ExecuteHandler("secret")
+
+	t.Run("unauthorized", func(t *testing.T) {
+		r := httptest.NewRequest(http.MethodPost, "/v1/execute", strings.NewReader("{}"))
+		r.Header.Set("Authorization", "Bearer wrong")
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		assert.Equal(t, w.Code, http.StatusUnauthorized)
+		assert.Equal(t, w.Header().Get("WWW-Authenticate"), "Bearer")
+	})
+
+	t.Run("JSON", func(t *testing.T) {
+		rs := postExecute(t, h, "application/json", "", strings.NewReader(`{"args":["-n"],"stdin":"hello\nworld\n"}`))
+		assert.Equal(t, rs.StatusCode, http.StatusOK)
+		var body executeResponse
+		assert.NilError(t, json.NewDecoder(rs.Body).Decode(&body))
+		assert.Equal(t, body.Content, "```\n     1\thello\n```")
+		assert.Equal(t, len(body.Files), 1)
+		assert.Equal(t, body.Files[0].Name, "stdout.log")
+		assert.Equal(t, string(body.Files[0].Content), "     1\thello\n     2\tworld\n")
+	})
+
+	t.Run("multipart", func(t *testing.T) {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		assert.NilError(t, mw.WriteField("args", "a.txt"))
+		fw, err := mw.CreateFormFile("files", "a.txt")
+		assert.NilError(t, err)
+		_, err = fw.Write([]byte("hello\n"))
+		assert.NilError(t, err)
+		assert.NilError(t, mw.Close())
+
+		rs := postExecute(t, h, mw.FormDataContentType(), "multipart/form-data", &buf)
+		assert.Equal(t, rs.StatusCode, http.StatusOK)
+		_, params, err := mime.ParseMediaType(rs.Header.Get("Content-Type"))
+		assert.NilError(t, err)
+		mr := multipart.NewReader(rs.Body, params["boundary"])
+		part, err := mr.NextPart()
+		assert.NilError(t, err)
+		assert.Equal(t, part.FormName(), "content")
+		content, err := io.ReadAll(part)
+		assert.NilError(t, err)
+		assert.Equal(t, string(content), "```\nhello\n```")
+		_, err = mr.NextPart()
+		assert.Equal(t, err, io.EOF)
+	})
+
+	t.Run("invalid file name", func(t *testing.T) {
+		rs := postExecute(t, h, "application/json", "", strings.NewReader(`{"files":[{"name":"../a.txt","content":""}]}`))
+		assert.Equal(t, rs.StatusCode, http.StatusBadRequest)
+	})
+
+	t.Run("draining", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		b.Shutdown(ctx, time.Second)
+		rs := postExecute(t, h, "application/json", "", strings.NewReader(`{}`))
+		assert.Equal(t, rs.StatusCode, http.StatusServiceUnavailable)
+	})
+}
@@ -2,22 +2,65 @@
 package client
 
 import (
+	"context"
+	"slices"
+	"time"
+
 	"github.com/disgoorg/disgo"
 	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/cache"
+	"github.com/disgoorg/disgo/discord"
 
 	"github.com/disgoorg/disgo/events"
 	"github.com/disgoorg/disgo/gateway"
+	"github.com/disgoorg/disgo/sharding"
 
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
 	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/replystore"
 )
 
+// intents are the gateway intents required by the bot.
+var intents = []gateway.Intents{
+	gateway.IntentGuilds,
+	gateway.IntentGuildMessages,
+	gateway.IntentDirectMessages,
+}
+
+// Bot is a Discord bot client that can drain in-flight jobs on shutdown.
+type Bot struct {
+	bot.Client
+	options  *options.Options
+	handler  *messageEventsHandler
+	presence *presence
+}
+
 // New creates and returns a new Discord bot client configured with the given options.
 // It registers all necessary event listeners for message and ready events.
-func New(o *options.Options) (bot.Client, error) {
-	handler := messageEventsHandler{options: o}
-	return disgo.New(o.DiscordToken,
+// If sharding is configured, the client uses a shard manager instead of a single gateway.
+// REST and the gateway connect to the configured endpoints through the configured proxy and CA bundle.
+// The opts are applied last, e.g. to connect to the emulator of package discordtest.
+func New(o *options.Options, opts ...bot.ConfigOpt) (*Bot, error) {
+	restOpts, gatewayOpts, err := transportConfigOpts(o)
+	if err != nil {
+		return nil, err
+	}
+	store, err := replystore.Open(o.ReplyStorePath, o.ReplyStoreCapacity)
+	if err != nil {
+		return nil, err
+	}
+	handler := newMessageEventsHandler(o, store)
+	p, err := newPresence(o, handler)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	var c bot.Client
+	c, err = disgo.New(o.DiscordToken, append([]bot.ConfigOpt{
 		bot.WithEventListeners(
-			bot.NewListenerFunc(func(e *events.Ready) { onReady(o, e) }),
+			bot.NewListenerFunc(func(e *events.Ready) { onReady(o, p, e) }),
+			bot.NewListenerFunc(func(e *events.GuildJoin) { onGuildJoin(o, e) }),
+			bot.NewListenerFunc(handler.reconcile),
 			bot.NewListenerFunc(handler.onMessageCreate),
 			bot.NewListenerFunc(handler.onMessageUpdate),
 			bot.NewListenerFunc(handler.onMessageDelete),
@@ -25,12 +68,98 @@ func New(o *options.Options) (bot.Client, error) {
 		bot.WithEventManagerConfigOpts(
 			bot.WithAsyncEventsEnabled(),
 		),
-		bot.WithGatewayConfigOpts(
-			gateway.WithIntents(
-				gateway.IntentGuilds,
-				gateway.IntentGuildMessages,
-				gateway.IntentDirectMessages,
-			),
+		// Guilds are cached to verify the nickname in each of them, with only the bot itself among the members.
+		bot.WithCacheConfigOpts(
+			cache.WithCaches(cache.FlagGuilds, cache.FlagMembers),
+			cache.WithMemberCachePolicy(func(m discord.Member) bool { return m.User.ID == c.ID() }),
 		),
-	)
+		bot.WithRestClientConfigOpts(restOpts...),
+		gatewayConfigOpt(o, gatewayOpts...),
+	}, opts...)...)
+	if err != nil {
+		_ = store.Close()
+		return nil, err
+	}
+	return &Bot{Client: c, options: o, handler: handler, presence: p}, nil
+}
+
+// Shutdown stops accepting new message events and waits up to drainTimeout for in-flight jobs
+// to finish and post their replies. Jobs still running after that are aborted, which kills their
+// process groups and replies that the bot is restarting. It returns when all jobs are done or ctx is done,
+// and then closes the reply store.
+func (b *Bot) Shutdown(ctx context.Context, drainTimeout time.Duration) {
+	b.handler.shutdown(ctx, drainTimeout)
+}
+
+// Executor returns the Executor bounding the target CLI processes of the bot,
+// so that other frontends such as IRC share the limit and the queue with Discord executions.
+func (b *Bot) Executor() *future.Executor {
+	return b.handler.executor
+}
+
+// Draining returns a readiness check that fails once Shutdown has been called.
+func (b *Bot) Draining() func(context.Context) error {
+	return func(context.Context) error {
+		if b.handler.isDraining() {
+			return ErrDraining
+		}
+		return nil
+	}
+}
+
+// Open connects the client to Discord through the shard manager or the gateway, whichever is configured.
+// It captures the target version for the presence in the background, so that a slow `VERSION_COMMAND` does not
+// delay connecting, and starts refreshing the presence and verifying the nickname periodically
+// until ctx is done, if configured.
+func Open(ctx context.Context, b *Bot) error {
+	if len(b.options.VersionCommand) > 0 {
+		go func() {
+			b.presence.captureVersion(ctx)
+			// Shards that became ready meanwhile show the status without the version until updated.
+			b.presence.applyReady(ctx, b.Client)
+		}()
+	}
+	if interval := b.options.PresenceInterval(); interval > 0 {
+		go b.presence.refresh(ctx, b.Client, interval)
+	}
+	if interval := b.options.NicknameCheckInterval(); interval > 0 {
+		go verifyNicknames(ctx, b.Client, b.options, interval)
+	}
+	if b.HasShardManager() {
+		b.ShardManager().Open(ctx)
+		return nil
+	}
+	return b.OpenGateway(ctx)
+}
+
+// gatewayConfigOpt returns the option configuring either a single gateway or a shard manager with gatewayOpts.
+// Without ShardCount, the shard count and shard IDs are taken from Discord's `/gateway/bot` endpoint.
+func gatewayConfigOpt(o *options.Options, gatewayOpts ...gateway.ConfigOpt) bot.ConfigOpt {
+	gatewayOpts = append([]gateway.ConfigOpt{gateway.WithIntents(intents...)}, gatewayOpts...)
+	if !o.Sharding() {
+		return bot.WithGatewayConfigOpts(gatewayOpts...)
+	}
+	opts := []sharding.ConfigOpt{
+		sharding.WithGatewayConfigOpts(gatewayOpts...),
+	}
+	if o.ShardCount > 0 {
+		shardIDs := o.ShardIDs
+		if len(shardIDs) == 0 {
+			shardIDs = make([]int, o.ShardCount)
+			for i := range shardIDs {
+				shardIDs[i] = i
+			}
+		}
+		opts = append(opts,
+			sharding.WithShardCount(o.ShardCount),
+			// Replace the shard IDs discovered from `/gateway/bot` instead of adding to them.
+			func(config *sharding.Config) {
+				config.ShardIDs = make(map[int]struct{}, len(shardIDs))
+				for _, id := range slices.Compact(slices.Sorted(slices.Values(shardIDs))) {
+					config.ShardIDs[id] = struct{}{}
+				}
+			},
+		)
+	}
+	return bot.WithShardManagerConfigOpts(opts...)
 }
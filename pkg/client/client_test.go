@@ -0,0 +1,93 @@
+package client
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/discordtest"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"gotest.tools/v3/assert"
+)
+
+// closeBot shuts down and closes the bot connected to the Server.
+// disgo starts the heartbeat of a gateway without synchronization, so closing the gateway before the heartbeat
+// has run races with it. Waiting for a heartbeat, then taking the lock of the gateway through Status, orders them.
+func closeBot(ctx context.Context, t *testing.T, s *discordtest.Server, b *Bot) {
+	t.Helper()
+	assert.Check(t, s.WaitHeartbeat(ctx))
+	if b.Client.HasGateway() {
+		_ = b.Client.Gateway().Status()
+	}
+	b.Shutdown(ctx, time.Second)
+	b.Close(ctx)
+}
+
+func TestBot(t *testing.T) {
+	const guildID snowflake.ID = 300
+	s := discordtest.NewServer(botUser)
+	defer s.Close()
+	s.AddGuild(guildID)
+	s.AddChannel(channelID, guildID, discord.ChannelTypeGuildText)
+
+	o := &options.Options{
+		DiscordAPIURL:                      s.APIURL(),
+		DiscordGatewayURL:                  s.GatewayURL(),
+		DiscordNickname:                    "cat bot",
+		DiscordToken:                       s.Token(),
+		EnvCommand:                         []string{"/usr/bin/env", "-i", "PATH=" + os.Getenv("PATH")},
+		NumberOfLinesToEmbedOutput:         20,
+		NumberOfLinesToEmbedUploadedOutput: 3,
+		RestTimeoutSeconds:                 10,
+		TargetCLI:                          "cat",
+		TimeoutSeconds:                     10,
+	}
+	b, err := New(o)
+	assert.NilError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NilError(t, Open(ctx, b))
+	defer closeBot(ctx, t, s, b)
+	assert.NilError(t, s.WaitReady(ctx))
+
+	// The nickname is updated on Ready.
+	_, err = s.WaitForRequest(ctx, func(r discordtest.Request) bool {
+		return r.Method == "PATCH" && r.Path == "/guilds/300/members/@me"
+	})
+	assert.NilError(t, err)
+	assert.Equal(t, s.Nick(guildID), "cat bot")
+
+	// A message mentioning the bot is replied to.
+	user := discord.User{ID: 400, Username: "user"}
+	source, err := s.CreateMessage(channelID, user, "<@100>```\nhello\n```", botUser)
+	assert.NilError(t, err)
+	isMessagesRequest := func(method string) func(discordtest.Request) bool {
+		return func(r discordtest.Request) bool {
+			return r.Method == method && strings.HasPrefix(r.Path, "/channels/200/messages")
+		}
+	}
+	_, err = s.WaitForRequest(ctx, isMessagesRequest("POST"))
+	assert.NilError(t, err)
+	messages := s.Messages(channelID)
+	assert.Equal(t, len(messages), 2)
+	reply := messages[1]
+	assert.Equal(t, reply.Content, "```\nhello\n```")
+	assert.Equal(t, *reply.MessageReference.MessageID, source.ID)
+
+	// Editing the message updates the reply.
+	_, err = s.UpdateMessage(channelID, source.ID, "<@100> -n```\nhello\n```")
+	assert.NilError(t, err)
+	_, err = s.WaitForRequest(ctx, isMessagesRequest("PATCH"))
+	assert.NilError(t, err)
+	assert.Equal(t, s.Messages(channelID)[1].Content, "```\n     1\thello\n```")
+
+	// Deleting the message deletes the reply.
+	assert.NilError(t, s.DeleteMessage(channelID, source.ID))
+	_, err = s.WaitForRequest(ctx, isMessagesRequest("DELETE"))
+	assert.NilError(t, err)
+	assert.Equal(t, len(s.Messages(channelID)), 0)
+}
@@ -5,15 +5,22 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"iter"
 	"log/slog"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/disgoorg/disgo/bot"
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
 	"github.com/disgoorg/snowflake/v2"
 	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/logging"
 	"github.com/norio-nomura/cli_discord_bot2/pkg/message"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/metrics"
 	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/replystore"
 	"github.com/norio-nomura/cli_discord_bot2/pkg/xiter"
 )
 
@@ -21,11 +28,45 @@ import (
 // It stores the latest event for each message and processes them in a thread-safe manner.
 type messageEventsHandler struct {
 	options *options.Options
-	syncMap sync.Map
+	replies replystore.Store
+	// discord returns the Discord API of the client that received an event.
+	discord func(bot.Client) message.Discord
+	// executor bounds the target CLI processes of all jobs, including those of other frontends sharing it.
+	executor *future.Executor
+	syncMap  sync.Map
+
+	// mu guards draining and adding to jobs so that no job starts after shutdown begins.
+	mu       sync.RWMutex
+	draining bool
+	jobs     sync.WaitGroup
+	// abortCtx is cancelled when in-flight jobs must be aborted on shutdown.
+	abortCtx context.Context
+	abort    context.CancelFunc
+
+	// reconciled is true once the reconciliation pass has started.
+	reconciled atomic.Bool
+}
+
+// newMessageEventsHandler creates a messageEventsHandler with the given options and reply store.
+// Its Executor runs up to MAX_CONCURRENT_EXECUTIONS target CLI processes with EXECUTION_QUEUE_SIZE waiting.
+func newMessageEventsHandler(o *options.Options, s replystore.Store) *messageEventsHandler {
+	abortCtx, abort := context.WithCancel(context.Background())
+	return &messageEventsHandler{
+		options:  o,
+		replies:  s,
+		discord:  message.NewDiscord,
+		executor: future.NewExecutor(o.ConcurrentExecutions(), o.ExecutionQueueSize),
+		abortCtx: abortCtx,
+		abort:    abort,
+	}
 }
 
+// restartingResult is the reply for jobs aborted on shutdown.
+var restartingResult = &message.ExecutionResult{Content: "bot restarting, please edit to retry"}
+
 // onMessageCreate handles the MessageCreate event and stores it for processing.
 func (q *messageEventsHandler) onMessageCreate(e *events.MessageCreate) {
+	metrics.CountEvent("MessageCreate")
 	if message.ShouldIgnore(e.GenericMessage) {
 		return
 	}
@@ -34,6 +75,7 @@ func (q *messageEventsHandler) onMessageCreate(e *events.MessageCreate) {
 
 // onMessageUpdate handles the MessageUpdate event and stores it for processing.
 func (q *messageEventsHandler) onMessageUpdate(e *events.MessageUpdate) {
+	metrics.CountEvent("MessageUpdate")
 	if message.ShouldIgnore(e.GenericMessage) {
 		return
 	}
@@ -42,6 +84,7 @@ func (q *messageEventsHandler) onMessageUpdate(e *events.MessageUpdate) {
 
 // onMessageDelete handles the MessageDelete event and stores it for processing.
 func (q *messageEventsHandler) onMessageDelete(e *events.MessageDelete) {
+	metrics.CountEvent("MessageDelete")
 	if e.Message.ID != 0 && message.ShouldIgnore(e.GenericMessage) {
 		return
 	}
@@ -50,12 +93,23 @@ func (q *messageEventsHandler) onMessageDelete(e *events.MessageDelete) {
 
 // storeLatestEventForMessageID stores the latest event for a given message ID in the sync map.
 // If the event is newly stored, it starts a goroutine to process events for that message ID.
+// Events are dropped once shutdown has begun.
 func (q *messageEventsHandler) storeLatestEventForMessageID(id snowflake.ID, e any) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.draining {
+		slog.Warn("Dropped event during shutdown", slog.Any("id", id))
+		return
+	}
 	ch := make(chan any, 1)
 	ch <- e // Store the event in the channel.
 	if old, stored := storeToSyncMap(&q.syncMap, id, ch); stored {
 		// If the value was newly stored, start a goroutine to process the event for the message ID.
-		go q.processEventsForMessageID(id)
+		q.jobs.Add(1)
+		go func() {
+			defer q.jobs.Done()
+			q.processEventsForMessageID(id)
+		}()
 	} else {
 		// If the value was updated, close the old channel to signal that it is no longer needed.
 		close(old)
@@ -96,9 +150,9 @@ func loadFromSyncMap[K, V any](m *sync.Map, k K) (V, error) {
 	return v, nil
 }
 
-// contextFromChannel creates a context that is cancelled when the provided channel is closed.
-func contextFromChannel[T any](ch chan T) context.Context {
-	ctx, cancel := context.WithCancel(context.Background())
+// contextFromChannel creates a context that is cancelled when the provided channel is closed or parent is done.
+func contextFromChannel[T any](parent context.Context, ch chan T) context.Context {
+	ctx, cancel := context.WithCancel(parent)
 	go func() {
 		// Ensure the context is cancelled when the channel is closed.
 		<-ch
@@ -107,9 +161,54 @@ func contextFromChannel[T any](ch chan T) context.Context {
 	return ctx
 }
 
+// isDraining returns true once shutdown has begun.
+func (q *messageEventsHandler) isDraining() bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	return q.draining
+}
+
+// shutdown stops accepting new events and waits for in-flight jobs, aborting them after drainTimeout.
+// The reply store is closed afterwards.
+func (q *messageEventsHandler) shutdown(ctx context.Context, drainTimeout time.Duration) {
+	q.mu.Lock()
+	q.draining = true
+	q.mu.Unlock()
+	defer func() {
+		if err := q.replies.Close(); err != nil {
+			slog.Error("Failed to close reply store", slog.Any("err", err))
+		}
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		q.jobs.Wait()
+		close(done)
+	}()
+	slog.Info("Draining in-flight jobs", slog.Duration("timeout", drainTimeout))
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+		slog.Info("Drained all jobs")
+		return
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	slog.Warn("Aborting remaining jobs")
+	q.abort()
+	select {
+	case <-done:
+		slog.Info("Aborted all jobs")
+	case <-ctx.Done():
+		slog.Error("Failed to wait for aborted jobs", slog.Any("err", ctx.Err()))
+	}
+}
+
 // processEventsForMessageID processes all events for a given message ID in order.
 // It handles command execution and reply management for the message, updating or deleting as needed.
 func (q *messageEventsHandler) processEventsForMessageID(id snowflake.ID) {
+	defer metrics.TrackInFlightMessage()()
 	for {
 		ch, err := loadFromSyncMap[snowflake.ID, chan any](&q.syncMap, id)
 		if err != nil {
@@ -121,76 +220,143 @@ func (q *messageEventsHandler) processEventsForMessageID(id snowflake.ID) {
 			slog.Error("Failed to receive event for message ID", slog.Any("id", id))
 			return
 		}
-		ctx := contextFromChannel(ch)
+		ctx := contextFromChannel(q.abortCtx, ch)
 		var gm *events.GenericMessage
+		var d message.Discord
+		executeCmds := false
 		executeCmdFutures := xiter.SeqOf[future.Future[*message.ExecutionResult]]()
 		repliesFuture := future.NewValue(xiter.SeqOf[discord.Message]())
 		repliesToBeDeletedFuture := future.NewValue(xiter.SeqOf[discord.Message]())
 		switch event := e.(type) {
 		case *events.MessageCreate:
 			gm = event.GenericMessage
-			executeCmdFutures = message.ExecuteCmds(ctx, q.options, gm)
+			d = q.discord(gm.Client())
+			executeCmds = true
 		case *events.MessageUpdate:
 			gm = event.GenericMessage
-			executeCmdFutures = message.ExecuteCmds(ctx, q.options, gm)
+			d = q.discord(gm.Client())
+			executeCmds = true
 			if gm.Message.Flags.Has(discord.MessageFlagHasThread) {
-				repliesFuture = message.GetRepliesInThread(q.options, gm)
-				repliesToBeDeletedFuture = message.GetReplies(q.options, gm)
+				repliesFuture = message.GetRepliesInThread(q.options, d, q.replies, gm)
+				repliesToBeDeletedFuture = message.GetReplies(q.options, d, q.replies, gm)
 			} else {
-				repliesFuture = message.GetReplies(q.options, gm)
+				repliesFuture = message.GetReplies(q.options, d, q.replies, gm)
 			}
 		case *events.MessageDelete:
 			gm = event.GenericMessage
-			repliesFuture = message.GetReplies(q.options, gm)
+			d = q.discord(gm.Client())
+			repliesFuture = message.GetReplies(q.options, d, q.replies, gm)
 		default:
 			slog.Error("Unknown event type", slog.Any("event", event))
 			return
 		}
-		cmdResults := future.Await(ctx, executeCmdFutures)
-		replies, err := repliesFuture.Await(ctx)
-		if err != nil && !errors.Is(err, context.Canceled) {
-			slog.Error("Failed to get replies from message", slog.Any("err", err))
-			return
+		// Attach a per-job logger so that the logs of this event can be correlated end to end.
+		logger := message.JobLogger(gm)
+		ctx = logging.WithLogger(ctx, logger)
+		logger.Debug("Processing message event", slog.String("event", fmt.Sprintf("%T", e)))
+		conversation := message.NewDiscordConversation(q.options, d, q.replies, gm)
+		if executeCmds {
+			m, err := message.IncomingFromDiscord(ctx, q.options, d, gm)
+			if err != nil {
+				executeCmdFutures = xiter.SeqOf(future.NewError[*message.ExecutionResult](err))
+			} else {
+				executeCmdFutures = message.ExecuteIncoming(ctx, q.options, conversation, m, future.WithExecutor(q.executor))
+			}
+		}
+		// ctx is cancelled asynchronously once a newer event is stored, so check the map before each change too.
+		superseded := func() bool {
+			latest, ok := q.syncMap.Load(id)
+			return !ok || latest != any(ch)
 		}
-		repliesToBeDeleted, err := repliesToBeDeletedFuture.Await(ctx)
-		if err != nil && !errors.Is(err, context.Canceled) {
-			slog.Error("Failed to get replies to be deleted from message", slog.Any("err", err))
+		q.syncReplies(ctx, conversation, superseded, executeCmdFutures, repliesFuture, repliesToBeDeletedFuture)
+		if q.syncMap.CompareAndDelete(id, ch) {
+			// If no newer event was stored, stop processing.
 			return
 		}
+	}
+}
 
-		deleted := q.syncMap.CompareAndDelete(id, ch)
-		if deleted {
-			// If the event was deleted, stop processing.
-			for z := range xiter.ZipLongest(cmdResults, replies) {
-				if z.OK1 && z.OK2 {
-					// If both the command result and replies are available, send the reply.
-					executionResult := z.V1.Value
-					reply := z.V2
-					if _, err := message.UpdateMessage(q.options, gm, reply, executionResult).Await(ctx); err != nil {
-						slog.Error("Failed to update message", slog.Any("replyID", reply.ID), slog.Any("err", err))
-						return
-					}
-				} else if z.OK1 {
-					executionResult := z.V1.Value
-					if _, err := message.SendReply(q.options, gm, executionResult).Await(ctx); err != nil {
-						slog.Error("Failed to send reply", slog.Any("err", err))
-						return
-					}
-				} else { // z.OK2
-					reply := z.V2
-					if _, err := message.DeleteMessage(q.options, gm, reply.ID).Await(ctx); err != nil {
-						slog.Error("Failed to delete reply", slog.Any("replyID", reply.ID), slog.Any("err", err))
-						return
-					}
-				}
-			}
-			for reply := range repliesToBeDeleted {
-				if _, err := message.DeleteMessage(q.options, gm, reply.ID).Await(ctx); err != nil {
-					slog.Error("Failed to delete reply", slog.Any("replyID", reply.ID), slog.Any("err", err))
-					return
-				}
-			}
-			return
+// syncReplies edits, sends and deletes the replies so that they match the command results,
+// editing each reply as soon as its command finishes.
+// Replies are managed within the REST timeout even if the job is aborted on shutdown,
+// but not once a newer event for the message supersedes this one, which superseded reports before ctx is cancelled.
+func (q *messageEventsHandler) syncReplies(
+	ctx context.Context,
+	conversation *message.DiscordConversation,
+	superseded func() bool,
+	cmdFutures iter.Seq[future.Future[*message.ExecutionResult]],
+	repliesFuture, repliesToBeDeletedFuture future.Future[iter.Seq[discord.Message]],
+) {
+	logger := logging.FromContext(ctx)
+	syncCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	defer context.AfterFunc(ctx, func() {
+		if q.abortCtx.Err() == nil {
+			cancel()
+		}
+	})()
+	// The replies and those to be deleted are looked up in parallel, e.g. in the channel and the thread.
+	found, err := future.All(repliesFuture, repliesToBeDeletedFuture).Await(syncCtx)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			logger.Error("Failed to get replies from message", slog.Any("err", err))
 		}
+		return
+	}
+	replies, repliesToBeDeleted := found[0], found[1]
+	results := xiter.MapValues(future.AwaitUnordered(ctx, cmdFutures), func(r future.Result[*message.ExecutionResult]) future.Result[*message.ExecutionResult] {
+		if errors.Is(r.Err, context.Canceled) && q.abortCtx.Err() != nil {
+			// The job was aborted on shutdown; reply that the bot is restarting to the commands cancelled by it.
+			// Commands that had already finished keep their results.
+			return future.Result[*message.ExecutionResult]{Value: restartingResult}
+		}
+		return r
+	})
+	guard := func(r message.Reply) message.Reply { return supersedableReply{r, superseded} }
+	err = message.SyncReplies(
+		syncCtx,
+		supersedableConversation{conversation, superseded},
+		results,
+		xiter.Map(conversation.Replies(replies), guard),
+		xiter.Map(conversation.Replies(repliesToBeDeleted), guard),
+	)
+	if err != nil && syncCtx.Err() == nil && !errors.Is(err, errSuperseded) {
+		logger.Error("Failed to sync replies", slog.Any("err", err))
+	}
+}
+
+// errSuperseded is returned instead of changing a reply once a newer event for the message has been stored.
+var errSuperseded = errors.New("superseded by a newer event")
+
+// supersedableConversation is a Conversation that stops sending replies once superseded returns true.
+type supersedableConversation struct {
+	message.Conversation
+	superseded func() bool
+}
+
+func (c supersedableConversation) Send(ctx context.Context, r *message.ExecutionResult) (message.Reply, error) {
+	if c.superseded() {
+		return nil, errSuperseded
+	}
+	return c.Conversation.Send(ctx, r)
+}
+
+// supersedableReply is a Reply that is no longer edited or deleted once superseded returns true.
+type supersedableReply struct {
+	message.Reply
+	superseded func() bool
+}
+
+func (r supersedableReply) Edit(ctx context.Context, result *message.ExecutionResult) error {
+	if r.superseded() {
+		return errSuperseded
+	}
+	return r.Reply.Edit(ctx, result)
+}
+
+func (r supersedableReply) Delete(ctx context.Context) error {
+	if r.superseded() {
+		return errSuperseded
 	}
+	return r.Reply.Delete(ctx)
 }
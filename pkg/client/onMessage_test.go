@@ -0,0 +1,281 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/message"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/message/messagetest"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/replystore"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/xiter"
+	"gotest.tools/v3/assert"
+)
+
+const channelID snowflake.ID = 200
+
+var botUser = discord.User{ID: 100, Username: "bot"}
+
+// newTestHandler returns a handler running `cat` against a fake Discord with a guild text channel.
+func newTestHandler(d *messagetest.Discord) *messageEventsHandler {
+	o := &options.Options{
+		EnvCommand:                         []string{"/usr/bin/env", "-i", "PATH=" + os.Getenv("PATH")},
+		NumberOfLinesToEmbedOutput:         20,
+		NumberOfLinesToEmbedUploadedOutput: 3,
+		RestTimeoutSeconds:                 10,
+		TargetCLI:                          "cat",
+		TimeoutSeconds:                     10,
+	}
+	q := newMessageEventsHandler(o, replystore.NewMemory(0))
+	q.discord = func(bot.Client) message.Discord { return d }
+	return q
+}
+
+// genericMessage returns the event data of the message.
+func genericMessage(m discord.Message) *events.GenericMessage {
+	return &events.GenericMessage{
+		GenericEvent: events.NewGenericEvent(nil, 0, 0),
+		MessageID:    m.ID,
+		Message:      m,
+		ChannelID:    m.ChannelID,
+	}
+}
+
+// edit changes the content of the message and returns the update event.
+func edit(m discord.Message, content string) *events.MessageUpdate {
+	m.Content = content
+	return &events.MessageUpdate{GenericMessage: genericMessage(m)}
+}
+
+func TestProcessEventsForMessageID(t *testing.T) {
+	d := messagetest.NewDiscord(botUser)
+	d.AddChannel(channelID, discord.ChannelTypeGuildText)
+	source := d.AddMessage(discord.Message{ChannelID: channelID, Content: "<@100>```\nhello\n```", Mentions: []discord.User{botUser}})
+	q := newTestHandler(d)
+
+	// A new message is replied to.
+	q.onMessageCreate(&events.MessageCreate{GenericMessage: genericMessage(source)})
+	q.jobs.Wait()
+	created := d.CallsOf("CreateMessage")
+	assert.Equal(t, len(created), 1)
+	assert.Equal(t, created[0].Content, "```\nhello\n```")
+	replyID := created[0].MessageID
+	reply := d.Messages(channelID)[1]
+	assert.Equal(t, reply.ID, replyID)
+	assert.Equal(t, *reply.MessageReference.MessageID, source.ID)
+
+	// An edit updates the reply found in the reply store without scanning the channel.
+	q.onMessageUpdate(edit(source, "<@100> -n```\nhello\n```"))
+	q.jobs.Wait()
+	updated := d.CallsOf("UpdateMessage")
+	assert.Equal(t, len(updated), 1)
+	assert.DeepEqual(t, updated[0], messagetest.Call{Op: "UpdateMessage", ChannelID: channelID, MessageID: replyID, Content: "```\n     1\thello\n```"})
+	assert.Equal(t, len(d.CallsOf("GetMessages")), 0)
+
+	// Without the reply store, the reply is found by scanning the channel.
+	q = newTestHandler(d)
+	q.onMessageUpdate(edit(source, "<@100>```\nworld\n```"))
+	q.jobs.Wait()
+	assert.Equal(t, len(d.CallsOf("GetMessages")), 1)
+	updated = d.CallsOf("UpdateMessage")
+	assert.Equal(t, len(updated), 2)
+	assert.Equal(t, updated[1].Content, "```\nworld\n```")
+
+	// An edit removing the mention deletes the reply.
+	q.onMessageUpdate(edit(source, "hello"))
+	q.jobs.Wait()
+	deleted := d.CallsOf("DeleteMessage")
+	assert.Equal(t, len(deleted), 1)
+	assert.Equal(t, deleted[0].MessageID, replyID)
+	assert.Equal(t, len(d.Messages(channelID)), 1)
+}
+
+func TestProcessEventsForMessageID_Delete(t *testing.T) {
+	d := messagetest.NewDiscord(botUser)
+	d.AddChannel(channelID, discord.ChannelTypeGuildText)
+	source := d.AddMessage(discord.Message{ChannelID: channelID, Content: "<@100>\n<@100> -n\n```\nhello\n```", Mentions: []discord.User{botUser}})
+	q := newTestHandler(d)
+	q.onMessageCreate(&events.MessageCreate{GenericMessage: genericMessage(source)})
+	q.jobs.Wait()
+	assert.Equal(t, len(d.CallsOf("CreateMessage")), 2)
+
+	assert.NilError(t, d.DeleteMessage(context.Background(), channelID, source.ID))
+	q.onMessageDelete(&events.MessageDelete{GenericMessage: &events.GenericMessage{
+		GenericEvent: events.NewGenericEvent(nil, 0, 0),
+		MessageID:    source.ID,
+		ChannelID:    channelID,
+	}})
+	q.jobs.Wait()
+	assert.Equal(t, len(d.CallsOf("DeleteMessage")), 3)
+	assert.Equal(t, len(d.Messages(channelID)), 0)
+}
+
+func TestProcessEventsForMessageID_Thread(t *testing.T) {
+	d := messagetest.NewDiscord(botUser)
+	d.AddChannel(channelID, discord.ChannelTypeGuildText)
+	source := d.AddMessage(discord.Message{ChannelID: channelID, Content: "<@100>```\nhello\n```", Mentions: []discord.User{botUser}})
+	q := newTestHandler(d)
+	q.onMessageCreate(&events.MessageCreate{GenericMessage: genericMessage(source)})
+	q.jobs.Wait()
+	replyID := d.CallsOf("CreateMessage")[0].MessageID
+
+	// Once a thread is started from the message, replies move into the thread.
+	d.AddThread(channelID, source.ID)
+	source = d.Messages(channelID)[0]
+	q.onMessageUpdate(edit(source, source.Content))
+	q.jobs.Wait()
+	created := d.CallsOf("CreateMessage")
+	assert.Equal(t, len(created), 2)
+	assert.Equal(t, created[1].ChannelID, source.ID)
+	threadReply := d.Messages(source.ID)[0]
+	assert.Equal(t, threadReply.Type, discord.MessageTypeDefault)
+	deleted := d.CallsOf("DeleteMessage")
+	assert.Equal(t, len(deleted), 1)
+	assert.DeepEqual(t, deleted[0], messagetest.Call{Op: "DeleteMessage", ChannelID: channelID, MessageID: replyID})
+}
+
+func TestProcessEventsForMessageID_Abort(t *testing.T) {
+	// Each command reads a named pipe, so that the test decides when it finishes.
+	dir := t.TempDir()
+	finished, blocked := filepath.Join(dir, "finished"), filepath.Join(dir, "blocked")
+	assert.NilError(t, syscall.Mkfifo(finished, 0o600))
+	assert.NilError(t, syscall.Mkfifo(blocked, 0o600))
+
+	d := messagetest.NewDiscord(botUser)
+	d.AddChannel(channelID, discord.ChannelTypeGuildText)
+	source := d.AddMessage(discord.Message{
+		ChannelID: channelID,
+		Content:   "<@100> " + finished + "\n<@100> " + blocked,
+		Mentions:  []discord.User{botUser},
+	})
+	q := newTestHandler(d)
+	q.onMessageCreate(&events.MessageCreate{GenericMessage: genericMessage(source)})
+
+	// Opening a pipe for writing waits until the command opens it for reading.
+	assert.NilError(t, os.WriteFile(finished, []byte("done\n"), 0o600))
+	w, err := os.OpenFile(blocked, os.O_WRONLY, 0)
+	assert.NilError(t, err)
+	defer w.Close()
+	// The reply of the finished command is sent without waiting for the blocked one.
+	for len(d.CallsOf("CreateMessage")) != 1 || q.executor.Running() != 1 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Shutdown aborts the blocked command; the finished one keeps its reply.
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	q.shutdown(ctx, 0)
+	created := d.CallsOf("CreateMessage")
+	assert.Equal(t, len(created), 2)
+	assert.Equal(t, created[0].Content, "`cat "+finished+"`\n```\ndone\n```")
+	assert.Equal(t, created[1].Content, restartingResult.Content)
+}
+
+func TestProcessEventsForMessageID_Superseded(t *testing.T) {
+	// The first command reads a named pipe, so that it is still running when the message is edited.
+	blocked := filepath.Join(t.TempDir(), "blocked")
+	assert.NilError(t, syscall.Mkfifo(blocked, 0o600))
+
+	d := messagetest.NewDiscord(botUser)
+	d.AddChannel(channelID, discord.ChannelTypeGuildText)
+	source := d.AddMessage(discord.Message{ChannelID: channelID, Content: "<@100> " + blocked, Mentions: []discord.User{botUser}})
+	q := newTestHandler(d)
+	q.onMessageCreate(&events.MessageCreate{GenericMessage: genericMessage(source)})
+
+	// Opening a pipe for writing waits until the command opens it for reading.
+	w, err := os.OpenFile(blocked, os.O_WRONLY, 0)
+	assert.NilError(t, err)
+	q.onMessageUpdate(edit(source, "<@100>```\nsecond\n```"))
+	// The superseded command may finish before it is cancelled, but its result is not sent.
+	_, _ = w.WriteString("first\n")
+	assert.NilError(t, w.Close())
+	q.jobs.Wait()
+	created := d.CallsOf("CreateMessage")
+	assert.Equal(t, len(created), 1)
+	assert.Equal(t, created[0].Content, "```\nsecond\n```")
+
+	t.Run("before ctx is cancelled", func(t *testing.T) {
+		q := newTestHandler(d)
+		conversation := message.NewDiscordConversation(q.options, d, q.replies, genericMessage(source))
+		results := xiter.SeqOf(future.NewValue(&message.ExecutionResult{Content: "stale"}))
+		replies := future.NewValue(slices.Values(d.Messages(channelID)[1:]))
+		q.syncReplies(context.Background(), conversation, func() bool { return true }, results, replies, replies)
+		assert.Equal(t, len(d.CallsOf("CreateMessage")), 1)
+		assert.Equal(t, len(d.CallsOf("UpdateMessage")), 0)
+		assert.Equal(t, len(d.CallsOf("DeleteMessage")), 0)
+	})
+}
+
+func TestProcessEventsForMessageID_Executor(t *testing.T) {
+	d := messagetest.NewDiscord(botUser)
+	d.AddChannel(channelID, discord.ChannelTypeGuildText)
+	var lines []string
+	for i := range 6 {
+		lines = append(lines, fmt.Sprintf("<@100> 0.%d", 20+i))
+	}
+	source := d.AddMessage(discord.Message{ChannelID: channelID, Content: strings.Join(lines, "\n"), Mentions: []discord.User{botUser}})
+	q := newTestHandler(d)
+	q.options.TargetCLI = "sleep"
+	q.executor = future.NewExecutor(2, 10)
+
+	// Sample the running commands until the replies are sent.
+	done := make(chan struct{})
+	peak := make(chan int)
+	go func() {
+		var p int
+		for {
+			select {
+			case <-done:
+				peak <- p
+				return
+			case <-time.After(5 * time.Millisecond):
+				p = max(p, q.executor.Running())
+			}
+		}
+	}()
+	start := time.Now()
+	q.onMessageCreate(&events.MessageCreate{GenericMessage: genericMessage(source)})
+	q.jobs.Wait()
+	close(done)
+	assert.Equal(t, <-peak, 2)
+	// Six commands of at least 0.2 seconds take at least three rounds on two workers.
+	assert.Assert(t, time.Since(start) >= 600*time.Millisecond, time.Since(start))
+	assert.Equal(t, len(d.CallsOf("CreateMessage")), len(lines))
+}
+
+func TestProcessEventsForMessageID_Error(t *testing.T) {
+	d := messagetest.NewDiscord(botUser)
+	d.AddChannel(channelID, discord.ChannelTypeGuildText)
+	source := d.AddMessage(discord.Message{ChannelID: channelID, Content: "<@100>```\nhello\n```", Mentions: []discord.User{botUser}})
+	q := newTestHandler(d)
+
+	// A failed reply is not recorded.
+	d.FailOn("CreateMessage", errors.New("boom"))
+	q.onMessageCreate(&events.MessageCreate{GenericMessage: genericMessage(source)})
+	q.jobs.Wait()
+	assert.Equal(t, len(d.CallsOf("CreateMessage")), 1)
+	_, ok := q.replies.Replies(source.ID)
+	assert.Assert(t, !ok)
+
+	// An edit retries the reply.
+	d.FailOn("CreateMessage", nil)
+	q.onMessageUpdate(edit(source, source.Content))
+	q.jobs.Wait()
+	assert.Equal(t, len(d.CallsOf("CreateMessage")), 2)
+	replies, ok := q.replies.Replies(source.ID)
+	assert.Assert(t, ok)
+	assert.Equal(t, len(replies), 1)
+}
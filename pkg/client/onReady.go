@@ -4,42 +4,84 @@ package client
 import (
 	"context"
 	"log/slog"
+	"time"
 
+	"github.com/disgoorg/disgo/bot"
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
-	"github.com/disgoorg/disgo/gateway"
 	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/snowflake/v2"
 	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
 )
 
 // onReady is an internal event handler for the Discord Ready event.
 // It sets the bot's presence and updates the nickname in all joined guilds if needed.
-func onReady(o *options.Options, e *events.Ready) {
-	nickname, playing := o.Discord()
-	err := e.Client().SetPresence(
-		context.TODO(),
-		gateway.WithPlayingActivity(playing),
-	)
-	if err != nil {
-		slog.Error("Failed to set presence", slog.Any("err", err))
-	} else {
-		slog.Info("`ready`: changed status to", slog.String("playing", playing))
-	}
+// With sharding, it is called once per shard and handles the guilds of that shard.
+func onReady(o *options.Options, p *presence, e *events.Ready) {
+	nickname, _ := o.Discord()
+	shardID := e.ShardID()
+	slog.Info("`ready`: shard is ready", slog.Int("shard.id", shardID), slog.Int("guilds", len(e.Guilds)))
+	// A new session does not keep the previous presence, so set it even if unchanged.
+	p.apply(context.TODO(), e.Client(), shardID, true)
 	for _, g := range e.Guilds {
-		member, err := e.Client().Rest().GetMember(g.ID, e.User.ID)
+		applyNickname(context.TODO(), e.Client(), g.ID, nickname)
+	}
+}
+
+// onGuildJoin is an internal event handler for the Discord GuildJoin event.
+// It updates the nickname in the joined guild so that it does not wait for the next Ready.
+func onGuildJoin(o *options.Options, e *events.GuildJoin) {
+	nickname, _ := o.Discord()
+	slog.Info("`guild join`: joined guild", slog.Any("guild.id", e.GuildID), slog.Int("shard.id", e.ShardID()))
+	applyNickname(context.TODO(), e.Client(), e.GuildID, nickname)
+}
+
+// verifyNicknames updates the nickname in every cached guild at each interval until ctx is done,
+// restoring it if a moderator changed it.
+func verifyNicknames(ctx context.Context, c bot.Client, o *options.Options, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		nickname, _ := o.Discord()
+		var guildIDs []snowflake.ID
+		c.Caches().GuildsForEach(func(g discord.Guild) { guildIDs = append(guildIDs, g.ID) })
+		slog.Debug("Verifying nicknames", slog.Int("guilds", len(guildIDs)))
+		for _, guildID := range guildIDs {
+			applyNickname(ctx, c, guildID, nickname)
+		}
+	}
+}
+
+// applyNickname updates the bot's nickname in the guild if it differs.
+// The member is taken from the cache, which `GUILD_CREATE` and `GUILD_MEMBER_UPDATE` keep up to date,
+// and only fetched if it is not cached yet.
+func applyNickname(ctx context.Context, c bot.Client, guildID snowflake.ID, nickname string) {
+	member, ok := c.Caches().Member(guildID, c.ID())
+	if !ok {
+		m, err := c.Rest().GetMember(guildID, c.ID(), rest.WithCtx(ctx))
 		if err != nil {
-			slog.Error("Failed to get member", slog.Any("guild.id", g.ID), slog.Any("err", err))
-			continue
+			slog.Error("Failed to get member", slog.Any("guild.id", guildID), slog.Any("err", err))
+			return
 		}
-		if member.Nick == nil || *member.Nick != nickname {
-			// UpdateCurrentMember() produces marshalling response error.
-			// err := e.Client().Rest().UpdateCurrentMember(g.ID, nickname)
-			err := e.Client().Rest().Do(rest.UpdateCurrentMember.Compile(nil, g.ID), discord.CurrentMemberUpdate{Nick: nickname}, nil)
-			if err != nil {
-				slog.Error("Failed to update member nickname", slog.Any("guild.id", g.ID), slog.Any("err", err))
-			} else {
-				slog.Info("Updated member nickname", slog.Any("guild.id", g.ID), slog.String("nickname", nickname))
-			}
+		member = *m
+	}
+	if member.Nick == nil || *member.Nick != nickname {
+		// UpdateCurrentMember() produces marshalling response error.
+		// err := c.Rest().UpdateCurrentMember(guildID, nickname)
+		err := c.Rest().Do(rest.UpdateCurrentMember.Compile(nil, guildID), discord.CurrentMemberUpdate{Nick: nickname}, nil, rest.WithCtx(ctx))
+		if err != nil {
+			slog.Error("Failed to update member nickname", slog.Any("guild.id", guildID), slog.Any("err", err))
+		} else {
+			slog.Info("Updated member nickname", slog.Any("guild.id", guildID), slog.String("nickname", nickname))
+			// The response is not decoded, so the cache is updated here rather than waiting for the event.
+			member.GuildID = guildID
+			member.Nick = &nickname
+			c.Caches().AddMember(member)
 		}
 	}
 }
@@ -0,0 +1,86 @@
+package client
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/discordtest"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"gotest.tools/v3/assert"
+)
+
+func TestNicknames(t *testing.T) {
+	const guildID snowflake.ID = 300
+	s := discordtest.NewServer(botUser)
+	defer s.Close()
+	s.AddGuild(guildID)
+
+	o := &options.Options{
+		DiscordAPIURL:      s.APIURL(),
+		DiscordGatewayURL:  s.GatewayURL(),
+		DiscordNickname:    "cat bot",
+		DiscordToken:       s.Token(),
+		EnvCommand:         []string{"/usr/bin/env", "-i", "PATH=" + os.Getenv("PATH")},
+		RestTimeoutSeconds: 10,
+		TargetCLI:          "cat",
+		TimeoutSeconds:     10,
+	}
+	b, err := New(o)
+	assert.NilError(t, err)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	assert.NilError(t, Open(ctx, b))
+	defer closeBot(ctx, t, s, b)
+	assert.NilError(t, s.WaitReady(ctx))
+
+	// countRequests returns the number of requests with the method and path.
+	countRequests := func(method, path string) int {
+		n := 0
+		for _, r := range s.Requests() {
+			if r.Method == method && r.Path == path {
+				n++
+			}
+		}
+		return n
+	}
+	// waitNick waits until the nickname in the guild has been updated n times.
+	waitNick := func(t *testing.T, guildID snowflake.ID, n int) {
+		t.Helper()
+		path := "/guilds/" + guildID.String() + "/members/@me"
+		for countRequests("PATCH", path) < n {
+			select {
+			case <-ctx.Done():
+				t.Fatalf("waiting for %d updates of %s: %v", n, path, ctx.Err())
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+	waitNick(t, guildID, 1)
+	assert.Equal(t, s.Nick(guildID), "cat bot")
+
+	t.Run("guild join", func(t *testing.T) {
+		const joinedID snowflake.ID = 301
+		assert.NilError(t, s.JoinGuild(joinedID))
+		waitNick(t, joinedID, 1)
+		assert.Equal(t, s.Nick(joinedID), "cat bot")
+	})
+
+	t.Run("verify restores the nickname", func(t *testing.T) {
+		assert.NilError(t, s.SetNick(guildID, "renamed"))
+		go verifyNicknames(ctx, b.Client, o, 10*time.Millisecond)
+		waitNick(t, guildID, 2)
+		assert.Equal(t, s.Nick(guildID), "cat bot")
+
+		// Unchanged nicknames are left as they are.
+		time.Sleep(50 * time.Millisecond)
+		assert.Equal(t, countRequests("PATCH", "/guilds/300/members/@me"), 2)
+		assert.Equal(t, countRequests("PATCH", "/guilds/301/members/@me"), 1)
+	})
+
+	// The members are taken from the cache filled by `GUILD_CREATE` rather than fetched.
+	assert.Equal(t, countRequests("GET", "/guilds/300/members/100"), 0)
+	assert.Equal(t, countRequests("GET", "/guilds/301/members/100"), 0)
+}
@@ -0,0 +1,166 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/gateway"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+)
+
+// presenceData is the data available to the `DISCORD_PLAYING` template.
+type presenceData struct {
+	// Version is the first line of the output of `VERSION_COMMAND`, or empty if not configured.
+	Version string
+	// Running is the number of target CLIs running on the Executor shared by Discord, the HTTP API and IRC.
+	Running int
+	// Queued is the number of executions waiting for a worker of the Executor.
+	Queued int
+	// Uptime is the time since the bot started, such as `3d4h` or `5m`.
+	Uptime string
+}
+
+// presence renders the playing status from the `DISCORD_PLAYING` template and keeps it up to date.
+type presence struct {
+	options  *options.Options
+	handler  *messageEventsHandler
+	template *template.Template
+	started  time.Time
+
+	mu      sync.Mutex
+	version string
+	// applied holds the status last applied to each shard, so that unchanged statuses are not sent again.
+	applied map[int]string
+}
+
+// newPresence parses the `DISCORD_PLAYING` template.
+func newPresence(o *options.Options, handler *messageEventsHandler) (*presence, error) {
+	_, playing := o.Discord()
+	tmpl, err := options.ParsePresenceTemplate(playing)
+	if err != nil {
+		return nil, err
+	}
+	return &presence{
+		options:  o,
+		handler:  handler,
+		template: tmpl,
+		started:  time.Now(),
+		applied:  make(map[int]string),
+	}, nil
+}
+
+// captureVersion runs `VERSION_COMMAND` like the target CLI and keeps the first line of its output.
+func (p *presence) captureVersion(ctx context.Context) {
+	if len(p.options.VersionCommand) == 0 {
+		return
+	}
+	ctx, cancel := p.options.ContextWithTimeout(ctx)
+	defer cancel()
+	args := slices.Concat(p.options.EnvCommand, p.options.TargetEnv(), p.options.VersionCommand)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = options.Environ()
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		slog.Error("Failed to run version command", slog.Any("command", p.options.VersionCommand), slog.Any("err", err))
+		return
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(string(output)), "\n")
+	slog.Info("Captured target version", slog.String("version", version))
+	p.mu.Lock()
+	p.version = version
+	p.mu.Unlock()
+}
+
+// render executes the template with the current data.
+func (p *presence) render() (string, error) {
+	p.mu.Lock()
+	version := p.version
+	p.mu.Unlock()
+	data := presenceData{
+		Version: version,
+		Running: p.handler.executor.Running(),
+		Queued:  p.handler.executor.Queued(),
+		Uptime:  formatUptime(time.Since(p.started)),
+	}
+	var b bytes.Buffer
+	if err := p.template.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("failed to render presence: %w", err)
+	}
+	return b.String(), nil
+}
+
+// apply sets the rendered status on the shard if it differs from the one last applied, or if force is true.
+func (p *presence) apply(ctx context.Context, c bot.Client, shardID int, force bool) {
+	playing, err := p.render()
+	if err != nil {
+		slog.Error("Failed to set presence", slog.Int("shard.id", shardID), slog.Any("err", err))
+		return
+	}
+	p.mu.Lock()
+	unchanged := p.applied[shardID] == playing
+	p.mu.Unlock()
+	if unchanged && !force {
+		return
+	}
+	if c.HasShardManager() {
+		err = c.SetPresenceForShard(ctx, shardID, gateway.WithPlayingActivity(playing))
+	} else {
+		err = c.SetPresence(ctx, gateway.WithPlayingActivity(playing))
+	}
+	if err != nil {
+		slog.Error("Failed to set presence", slog.Int("shard.id", shardID), slog.Any("err", err))
+		return
+	}
+	p.mu.Lock()
+	p.applied[shardID] = playing
+	p.mu.Unlock()
+	slog.Info("Changed status to", slog.Int("shard.id", shardID), slog.String("playing", playing))
+}
+
+// refresh applies the status to every ready shard at each interval until ctx is done.
+// Only changed statuses are sent, which keeps presence updates well below the gateway rate limit.
+func (p *presence) refresh(ctx context.Context, c bot.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		p.applyReady(ctx, c)
+	}
+}
+
+// applyReady applies the status to every ready shard if it changed.
+func (p *presence) applyReady(ctx context.Context, c bot.Client) {
+	for shardID, status := range ShardStatuses(c) {
+		if status == gateway.StatusReady {
+			p.apply(ctx, c, shardID, false)
+		}
+	}
+}
+
+// formatUptime formats d with its two most significant units among days, hours and minutes.
+func formatUptime(d time.Duration) string {
+	days := int(d / (24 * time.Hour))
+	hours := int(d/time.Hour) % 24
+	minutes := int(d/time.Minute) % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
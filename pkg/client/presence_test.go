@@ -0,0 +1,80 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/replystore"
+	"gotest.tools/v3/assert"
+)
+
+func TestFormatUptime(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{0, "0m"},
+		{59 * time.Second, "0m"},
+		{5 * time.Minute, "5m"},
+		{time.Hour, "1h0m"},
+		{90 * time.Minute, "1h30m"},
+		{23*time.Hour + 59*time.Minute, "23h59m"},
+		{24 * time.Hour, "1d0h"},
+		{3*24*time.Hour + 4*time.Hour + 30*time.Minute, "3d4h"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, formatUptime(tt.d), tt.want, "%s", tt.d)
+	}
+}
+
+func TestPresenceRender(t *testing.T) {
+	// One execution runs and two wait for it on the Executor.
+	e := future.NewExecutor(1, 5)
+	release := make(chan struct{})
+	defer close(release)
+	for range 3 {
+		future.New(context.Background(), func(context.Context) (int, error) {
+			<-release
+			return 0, nil
+		}, future.WithExecutor(e))
+	}
+	for e.Running() != 1 || e.Queued() != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	tests := []struct {
+		playing string
+		want    string
+	}{
+		{"", "swift"},
+		{"Swift", "Swift"},
+		{"{{.Version}}", "Swift version 6.0"},
+		{"{{.Running}} running", "1 running"},
+		{"{{.Queued}} queued", "2 queued"},
+		{"up {{.Uptime}}", "up 1h30m"},
+		{"{{if .Queued}}busy{{else}}idle{{end}}", "busy"},
+	}
+	for _, tt := range tests {
+		o := &options.Options{TargetCLI: "swift", DiscordPlaying: tt.playing}
+		h := newMessageEventsHandler(o, replystore.NewMemory(0))
+		h.executor = e
+		p, err := newPresence(o, h)
+		assert.NilError(t, err)
+		p.version = "Swift version 6.0"
+		p.started = time.Now().Add(-90*time.Minute - time.Second)
+		got, err := p.render()
+		assert.NilError(t, err, tt.playing)
+		assert.Equal(t, got, tt.want, tt.playing)
+	}
+
+	t.Run("missing field", func(t *testing.T) {
+		o := &options.Options{TargetCLI: "swift", DiscordPlaying: "{{.Unknown}}"}
+		p, err := newPresence(o, newMessageEventsHandler(o, replystore.NewMemory(0)))
+		assert.NilError(t, err)
+		_, err = p.render()
+		assert.ErrorContains(t, err, "failed to render presence")
+	})
+}
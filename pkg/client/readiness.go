@@ -0,0 +1,44 @@
+// Package client provides Discord client setup and event handling utilities.
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/gateway"
+)
+
+// ShardStatuses returns the status of each shard keyed by shard ID.
+// Without sharding, the single gateway is reported as its shard ID.
+func ShardStatuses(c bot.Client) map[int]gateway.Status {
+	statuses := make(map[int]gateway.Status)
+	if c.HasShardManager() {
+		for id, shard := range c.ShardManager().Shards() {
+			statuses[id] = shard.Status()
+		}
+	} else if c.HasGateway() {
+		statuses[c.Gateway().ShardID()] = c.Gateway().Status()
+	}
+	return statuses
+}
+
+// GatewayReady returns a readiness check that succeeds once every shard is connected and has received the Ready event.
+func GatewayReady(c bot.Client) func(context.Context) error {
+	return func(context.Context) error {
+		statuses := ShardStatuses(c)
+		if len(statuses) == 0 {
+			return errors.New("gateway is not configured")
+		}
+		var errs []error
+		for _, id := range slices.Sorted(maps.Keys(statuses)) {
+			if status := statuses[id]; status != gateway.StatusReady {
+				errs = append(errs, fmt.Errorf("shard %d is %s", id, status))
+			}
+		}
+		return errors.Join(errs...)
+	}
+}
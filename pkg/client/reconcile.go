@@ -0,0 +1,141 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/message"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/replystore"
+)
+
+// reconcile handles the first Ready event by catching up on answered messages that were edited or deleted
+// while the bot was offline. Only the messages recorded in the reply store within ReconcileWindow are checked.
+// Edited messages are processed again as MessageUpdate, and deleted ones as MessageDelete.
+func (q *messageEventsHandler) reconcile(e *events.Ready) {
+	window := q.options.ReconcileWindow()
+	// Ready is sent for each shard and on every reconnection, while the pass covers all the sources,
+	// and events missed during a reconnection are replayed by resuming the session; one pass per process is enough.
+	if window <= 0 || !q.reconciled.CompareAndSwap(false, true) {
+		return
+	}
+	c := e.Client()
+	checked := q.reconcileSources(c, q.discord(c), time.Now().Add(-window))
+	slog.Info("Reconciled answered messages", slog.Int("count", checked))
+}
+
+// reconcileSources reconciles the sources in the reply store posted after since, and returns how many were checked.
+func (q *messageEventsHandler) reconcileSources(c bot.Client, d message.Discord, since time.Time) int {
+	checked := 0
+	for _, source := range q.replies.Sources() {
+		if q.isDraining() {
+			break
+		}
+		if source.Time().Before(since) {
+			continue
+		}
+		replies, ok := q.replies.Replies(source)
+		if !ok {
+			continue
+		}
+		checked++
+		if err := q.reconcileMessage(c, d, source, replies); err != nil {
+			slog.Error("Failed to reconcile message", slog.Any("message.id", source), slog.Any("err", err))
+		}
+	}
+	return checked
+}
+
+// reconcileMessage checks whether the source message was deleted, or edited after its last reply,
+// and if so stores the corresponding event for processing.
+func (q *messageEventsHandler) reconcileMessage(c bot.Client, d message.Discord, source snowflake.ID, replies []replystore.Reply) error {
+	ctx, cancel := q.options.ContextWithRestTimeout(q.abortCtx)
+	defer cancel()
+	channelID, err := sourceChannelID(ctx, d, source, replies)
+	if err != nil {
+		return err
+	}
+	gm := &events.GenericMessage{
+		GenericEvent: events.NewGenericEvent(c, 0, 0),
+		MessageID:    source,
+		ChannelID:    channelID,
+	}
+	m, err := d.GetMessage(ctx, channelID, source)
+	if message.IsNotFound(err) {
+		slog.Info("Reconciling deleted message", slog.Any("message.id", source), slog.Any("channel.id", channelID))
+		q.storeLatestEventForMessageID(source, &events.MessageDelete{GenericMessage: gm})
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to get message: %w", err)
+	}
+	if m.EditedTimestamp == nil {
+		return nil
+	}
+	answered, err := lastAnsweredAt(ctx, d, replies)
+	if err != nil {
+		return err
+	}
+	if !m.EditedTimestamp.After(answered) {
+		return nil
+	}
+	gm.GenericEvent = events.NewGenericEvent(c, 0, shardIDOf(c, m.GuildID))
+	gm.Message = *m
+	gm.GuildID = m.GuildID
+	if message.ShouldIgnore(gm) {
+		return nil
+	}
+	slog.Info("Reconciling edited message", slog.Any("message.id", source), slog.Any("channel.id", channelID))
+	q.storeLatestEventForMessageID(source, &events.MessageUpdate{GenericMessage: gm})
+	return nil
+}
+
+// shardIDOf returns the shard receiving the events of the guild, or 0 if the guild is unknown
+// as for a deleted message, the client is not sharded, or the shard is handled by another process.
+func shardIDOf(c bot.Client, guildID *snowflake.ID) int {
+	if c == nil || guildID == nil || !c.HasShardManager() {
+		return 0
+	}
+	if shard := c.ShardManager().ShardByGuildID(*guildID); shard != nil {
+		return shard.ShardID()
+	}
+	return 0
+}
+
+// sourceChannelID returns the channel of the source message.
+// Replies are posted in the same channel, or in the thread started from the source message,
+// whose ID is the source message ID and whose parent is the channel.
+func sourceChannelID(ctx context.Context, d message.Discord, source snowflake.ID, replies []replystore.Reply) (snowflake.ID, error) {
+	for _, r := range replies {
+		if r.ChannelID != source {
+			return r.ChannelID, nil
+		}
+	}
+	ch, err := d.GetChannel(ctx, source)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get thread: %w", err)
+	}
+	thread, ok := ch.(discord.GuildThread)
+	if !ok {
+		return 0, fmt.Errorf("channel %s is not a thread", source)
+	}
+	return *thread.ParentID(), nil
+}
+
+// lastAnsweredAt returns when the latest reply was posted or last edited.
+func lastAnsweredAt(ctx context.Context, d message.Discord, replies []replystore.Reply) (time.Time, error) {
+	latest := slices.MaxFunc(replies, func(r1, r2 replystore.Reply) int { return r1.MessageID.Time().Compare(r2.MessageID.Time()) })
+	m, err := d.GetMessage(ctx, latest.ChannelID, latest.MessageID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get reply %s: %w", latest.MessageID, err)
+	}
+	if m.EditedTimestamp != nil {
+		return *m.EditedTimestamp, nil
+	}
+	return m.CreatedAt, nil
+}
@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/message/messagetest"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/replystore"
+	"gotest.tools/v3/assert"
+)
+
+func TestReconcileSources(t *testing.T) {
+	// setup returns a handler whose store records the reply to a source message edited at the given time.
+	setup := func(t *testing.T, edited *time.Time) (*messageEventsHandler, *messagetest.Discord, discord.Message, discord.Message) {
+		d := messagetest.NewDiscord(botUser)
+		d.AddChannel(channelID, discord.ChannelTypeGuildText)
+		source := d.AddMessage(discord.Message{
+			ChannelID:       channelID,
+			Content:         "<@100>```\nworld\n```",
+			Mentions:        []discord.User{botUser},
+			EditedTimestamp: edited,
+		})
+		reply := d.AddMessage(discord.Message{
+			ChannelID:        channelID,
+			Author:           botUser,
+			Content:          "```\nhello\n```",
+			Type:             discord.MessageTypeReply,
+			MessageReference: &discord.MessageReference{MessageID: &source.ID},
+		})
+		q := newTestHandler(d)
+		assert.NilError(t, q.replies.Add(source.ID, replystore.Reply{ChannelID: channelID, MessageID: reply.ID}))
+		return q, d, source, reply
+	}
+	since := time.Now().Add(-time.Hour)
+
+	t.Run("deleted while offline", func(t *testing.T) {
+		q, d, source, reply := setup(t, nil)
+		assert.NilError(t, d.DeleteMessage(context.Background(), channelID, source.ID))
+		assert.Equal(t, q.reconcileSources(nil, d, since), 1)
+		q.jobs.Wait()
+		deleted := d.CallsOf("DeleteMessage")
+		assert.Equal(t, len(deleted), 2)
+		assert.Equal(t, deleted[1].MessageID, reply.ID)
+		assert.Equal(t, len(d.Messages(channelID)), 0)
+	})
+
+	t.Run("edited after the last reply", func(t *testing.T) {
+		edited := time.Now().Add(time.Minute)
+		q, d, _, reply := setup(t, &edited)
+		assert.Equal(t, q.reconcileSources(nil, d, since), 1)
+		q.jobs.Wait()
+		updated := d.CallsOf("UpdateMessage")
+		assert.Equal(t, len(updated), 1)
+		assert.Equal(t, updated[0].MessageID, reply.ID)
+		assert.Equal(t, updated[0].Content, "```\nworld\n```")
+	})
+
+	t.Run("not edited", func(t *testing.T) {
+		q, d, _, _ := setup(t, nil)
+		assert.Equal(t, q.reconcileSources(nil, d, since), 1)
+		q.jobs.Wait()
+		assert.Equal(t, len(d.CallsOf("GetMessage")), 1)
+		assert.Equal(t, len(d.CallsOf("UpdateMessage")), 0)
+		assert.Equal(t, len(d.CallsOf("DeleteMessage")), 0)
+	})
+
+	t.Run("edited before the last reply", func(t *testing.T) {
+		edited := time.Now().Add(-time.Minute)
+		q, d, _, _ := setup(t, &edited)
+		assert.Equal(t, q.reconcileSources(nil, d, since), 1)
+		q.jobs.Wait()
+		assert.Equal(t, len(d.CallsOf("GetMessage")), 2)
+		assert.Equal(t, len(d.CallsOf("UpdateMessage")), 0)
+	})
+
+	t.Run("outside the window", func(t *testing.T) {
+		q, d, _, _ := setup(t, nil)
+		assert.Equal(t, q.reconcileSources(nil, d, time.Now().Add(time.Hour)), 0)
+		assert.Equal(t, len(d.CallsOf("GetMessage")), 0)
+	})
+}
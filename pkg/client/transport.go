@@ -0,0 +1,54 @@
+package client
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/disgoorg/disgo/gateway"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/gorilla/websocket"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+)
+
+// restHTTPTimeout matches the timeout of the HTTP client disgo uses by default.
+const restHTTPTimeout = 20 * time.Second
+
+// transportConfigOpts returns the REST and gateway options connecting to the configured endpoints
+// through the configured proxy and CA bundle. Attachments are downloaded with the same HTTP client as REST.
+// Without a proxy or CA bundle, disgo's HTTP client and dialer are kept, which honor `HTTPS_PROXY` and friends.
+func transportConfigOpts(o *options.Options) ([]rest.ConfigOpt, []gateway.ConfigOpt, error) {
+	var restOpts []rest.ConfigOpt
+	var gatewayOpts []gateway.ConfigOpt
+	if o.DiscordAPIURL != "" {
+		restOpts = append(restOpts, rest.WithURL(strings.TrimSuffix(o.DiscordAPIURL, "/")))
+	}
+	if o.DiscordGatewayURL != "" {
+		gatewayOpts = append(gatewayOpts, gateway.WithURL(o.DiscordGatewayURL))
+	}
+	proxyURL, err := o.ProxyURL()
+	if err != nil {
+		return nil, nil, err
+	}
+	tlsConfig, err := o.TLSConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	if proxyURL == nil && tlsConfig == nil {
+		return restOpts, gatewayOpts, nil
+	}
+	proxy := http.ProxyFromEnvironment
+	if proxyURL != nil {
+		proxy = http.ProxyURL(proxyURL)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy
+	transport.TLSClientConfig = tlsConfig
+	dialer := *websocket.DefaultDialer
+	dialer.Proxy = proxy
+	dialer.TLSClientConfig = tlsConfig
+	restOpts = append(restOpts, rest.WithHTTPClient(&http.Client{Transport: transport, Timeout: restHTTPTimeout}))
+	gatewayOpts = append(gatewayOpts, gateway.WithDialer(&dialer))
+	return restOpts, gatewayOpts, nil
+}
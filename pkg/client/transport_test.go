@@ -0,0 +1,53 @@
+package client
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/disgoorg/disgo/gateway"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"gotest.tools/v3/assert"
+)
+
+func TestTransportConfigOpts(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer proxy.Close()
+
+	o := &options.Options{
+		DiscordAPIURL:     "http://discord.invalid/api/v10/",
+		DiscordGatewayURL: "ws://gateway.invalid",
+		HTTPProxyURL:      proxy.URL,
+	}
+	restOpts, gatewayOpts, err := transportConfigOpts(o)
+	assert.NilError(t, err)
+
+	restConfig := rest.DefaultConfig()
+	restConfig.Apply(restOpts)
+	assert.Equal(t, restConfig.URL, "http://discord.invalid/api/v10")
+	rs, err := restConfig.HTTPClient.Get("http://discord.invalid/attachments/1/file.txt")
+	assert.NilError(t, err)
+	assert.NilError(t, rs.Body.Close())
+	assert.DeepEqual(t, proxied, []string{"http://discord.invalid/attachments/1/file.txt"})
+
+	gatewayConfig := gateway.DefaultConfig()
+	gatewayConfig.Apply(gatewayOpts)
+	assert.Equal(t, gatewayConfig.URL, "ws://gateway.invalid")
+	rq, err := http.NewRequest(http.MethodGet, "https://gateway.invalid", nil)
+	assert.NilError(t, err)
+	proxyURL, err := gatewayConfig.Dialer.Proxy(rq)
+	assert.NilError(t, err)
+	assert.Equal(t, proxyURL.String(), proxy.URL)
+}
+
+func TestTransportConfigOpts_Default(t *testing.T) {
+	restOpts, gatewayOpts, err := transportConfigOpts(&options.Options{})
+	assert.NilError(t, err)
+	assert.Equal(t, len(restOpts), 0)
+	assert.Equal(t, len(gatewayOpts), 0)
+}
@@ -0,0 +1,745 @@
+// Package discordtest provides an httptest-based emulator of the Discord REST API and gateway,
+// so that a real disgo client can be tested end to end without network access.
+//
+// The emulator implements only the endpoints and gateway operations used by this bot.
+// Connect a client with the options returned by Server.ConfigOpts and the token returned by Server.Token.
+package discordtest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/gorilla/websocket"
+)
+
+// apiPrefix is the path prefix of the REST API.
+const apiPrefix = "/api/v10"
+
+// Request records a REST request received by the Server.
+type Request struct {
+	Method string
+	// Path is the path without the API prefix, such as `/channels/1/messages`.
+	Path string
+	// Body is the JSON payload of the request, taken from `payload_json` for multipart requests.
+	Body string
+}
+
+// channel is an emulated channel.
+type channel struct {
+	Type     discord.ChannelType
+	GuildID  snowflake.ID
+	ParentID snowflake.ID
+}
+
+// Server emulates the Discord REST API and gateway for a single bot user.
+type Server struct {
+	*httptest.Server
+	self discord.User
+
+	mu       sync.Mutex
+	guilds   []snowflake.ID
+	nicks    map[snowflake.ID]string
+	channels map[snowflake.ID]channel
+	messages map[snowflake.ID][]discord.Message // by channel, ascending order of ID
+	requests []Request
+	sessions []*session
+	presence []json.RawMessage
+	lastID   snowflake.ID
+}
+
+// NewServer starts a Server for the bot user self.
+func NewServer(self discord.User) *Server {
+	self.Bot = true
+	s := &Server{
+		self:     self,
+		nicks:    make(map[snowflake.ID]string),
+		channels: make(map[snowflake.ID]channel),
+		messages: make(map[snowflake.ID][]discord.Message),
+		lastID:   snowflake.New(time.Now()),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /gateway", s.serveGateway)
+	mux.HandleFunc("GET "+apiPrefix+"/gateway", s.getGateway)
+	mux.HandleFunc("GET "+apiPrefix+"/gateway/bot", s.getGateway)
+	mux.HandleFunc("GET "+apiPrefix+"/channels/{channel}", s.getChannel)
+	mux.HandleFunc("GET "+apiPrefix+"/channels/{channel}/messages", s.getMessages)
+	mux.HandleFunc("GET "+apiPrefix+"/channels/{channel}/messages/{message}", s.getMessage)
+	mux.HandleFunc("POST "+apiPrefix+"/channels/{channel}/messages", s.createMessage)
+	mux.HandleFunc("PATCH "+apiPrefix+"/channels/{channel}/messages/{message}", s.updateMessage)
+	mux.HandleFunc("DELETE "+apiPrefix+"/channels/{channel}/messages/{message}", s.deleteMessage)
+	mux.HandleFunc("POST "+apiPrefix+"/channels/{channel}/typing", s.noContent)
+	mux.HandleFunc("GET "+apiPrefix+"/guilds/{guild}/members/{user}", s.getMember)
+	mux.HandleFunc("PATCH "+apiPrefix+"/guilds/{guild}/members/@me", s.updateCurrentMember)
+	mux.HandleFunc(apiPrefix+"/", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, 0, "404: Not Found")
+	})
+	s.Server = httptest.NewServer(s.record(mux))
+	return s
+}
+
+// Token returns a bot token carrying the ID of the bot user, as disgo derives the ID from the token.
+func (s *Server) Token() string {
+	return base64.RawStdEncoding.EncodeToString([]byte(s.self.ID.String())) + ".emulated.token"
+}
+
+// ConfigOpts returns the options connecting a disgo client to the Server.
+// The gateway URL is then fetched from the Server like from Discord.
+func (s *Server) ConfigOpts() []bot.ConfigOpt {
+	return []bot.ConfigOpt{bot.WithRestClientConfigOpts(rest.WithURL(s.APIURL()))}
+}
+
+// APIURL returns the base URL of the REST API of the Server, e.g. for `DISCORD_API_URL`.
+func (s *Server) APIURL() string {
+	return s.URL + apiPrefix
+}
+
+// GatewayURL returns the URL of the gateway of the Server, e.g. for `DISCORD_GATEWAY_URL`.
+func (s *Server) GatewayURL() string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + "/gateway"
+}
+
+// Close closes the gateway sessions and shuts down the Server.
+func (s *Server) Close() {
+	s.mu.Lock()
+	sessions := slices.Clone(s.sessions)
+	s.mu.Unlock()
+	for _, ss := range sessions {
+		_ = ss.conn.Close()
+	}
+	s.Server.Close()
+}
+
+// AddGuild adds a guild that the bot is a member of. It is sent on Ready to gateway sessions opened afterwards.
+func (s *Server) AddGuild(id snowflake.ID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.guilds = append(s.guilds, id)
+}
+
+// JoinGuild adds a guild like AddGuild and dispatches `GUILD_CREATE` as when the bot is invited to it.
+func (s *Server) JoinGuild(id snowflake.ID) error {
+	s.AddGuild(id)
+	return s.Dispatch("GUILD_CREATE", s.guildCreate(id))
+}
+
+// SetNick changes the nickname of the bot in the guild as a moderator would, and dispatches `GUILD_MEMBER_UPDATE`,
+// which Discord sends for the bot itself without the `GUILD_MEMBERS` intent.
+func (s *Server) SetNick(guildID snowflake.ID, nick string) error {
+	s.mu.Lock()
+	s.nicks[guildID] = nick
+	s.mu.Unlock()
+	member := s.member(guildID)
+	member["guild_id"] = guildID
+	return s.Dispatch("GUILD_MEMBER_UPDATE", member)
+}
+
+// AddChannel adds a channel of the type in the guild, or a DM channel if guildID is 0.
+func (s *Server) AddChannel(id, guildID snowflake.ID, typ discord.ChannelType) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.channels[id] = channel{Type: typ, GuildID: guildID}
+}
+
+// Nick returns the nickname of the bot in the guild.
+func (s *Server) Nick(guildID snowflake.ID) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.nicks[guildID]
+}
+
+// Messages returns the messages in the channel in ascending order of ID.
+func (s *Server) Messages(channelID snowflake.ID) []discord.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.messages[channelID])
+}
+
+// Requests returns the REST requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.requests)
+}
+
+// Presences returns the data of the presence updates received from gateway sessions.
+func (s *Server) Presences() []json.RawMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.presence)
+}
+
+// WaitForRequest waits until a REST request matching match has been received, and returns the first one.
+func (s *Server) WaitForRequest(ctx context.Context, match func(Request) bool) (Request, error) {
+	return poll(ctx, func() (Request, bool) {
+		requests := s.Requests()
+		if i := slices.IndexFunc(requests, match); i >= 0 {
+			return requests[i], true
+		}
+		return Request{}, false
+	})
+}
+
+// WaitReady waits until a gateway session has been sent Ready.
+func (s *Server) WaitReady(ctx context.Context) error {
+	_, err := poll(ctx, func() (struct{}, bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return struct{}{}, slices.ContainsFunc(s.sessions, (*session).isReady)
+	})
+	return err
+}
+
+// WaitHeartbeat waits until a gateway session that has been sent Ready has acknowledged a heartbeat.
+// disgo starts its heartbeat goroutine without synchronization, so a client closed before that goroutine
+// has sent a heartbeat races with it. Tests should wait for this before closing the client.
+func (s *Server) WaitHeartbeat(ctx context.Context) error {
+	_, err := poll(ctx, func() (struct{}, bool) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return struct{}{}, slices.ContainsFunc(s.sessions, (*session).isBeating)
+	})
+	return err
+}
+
+// CreateMessage posts a message by the author in the channel and dispatches `MESSAGE_CREATE`.
+func (s *Server) CreateMessage(channelID snowflake.ID, author discord.User, content string, mentions ...discord.User) (discord.Message, error) {
+	s.mu.Lock()
+	m := s.newMessage(channelID, author, content)
+	m.Mentions = mentions
+	s.messages[channelID] = append(s.messages[channelID], m)
+	s.mu.Unlock()
+	return m, s.Dispatch("MESSAGE_CREATE", m)
+}
+
+// UpdateMessage edits the content of a message and dispatches `MESSAGE_UPDATE`.
+func (s *Server) UpdateMessage(channelID, messageID snowflake.ID, content string) (discord.Message, error) {
+	s.mu.Lock()
+	i, found := s.findMessage(channelID, messageID)
+	if !found {
+		s.mu.Unlock()
+		return discord.Message{}, fmt.Errorf("unknown message %s", messageID)
+	}
+	m := &s.messages[channelID][i]
+	m.Content = content
+	now := time.Now()
+	m.EditedTimestamp = &now
+	updated := *m
+	s.mu.Unlock()
+	return updated, s.Dispatch("MESSAGE_UPDATE", updated)
+}
+
+// DeleteMessage deletes a message and dispatches `MESSAGE_DELETE`.
+func (s *Server) DeleteMessage(channelID, messageID snowflake.ID) error {
+	s.mu.Lock()
+	i, found := s.findMessage(channelID, messageID)
+	if found {
+		s.messages[channelID] = slices.Delete(s.messages[channelID], i, i+1)
+	}
+	guildID := s.channels[channelID].GuildID
+	s.mu.Unlock()
+	if !found {
+		return fmt.Errorf("unknown message %s", messageID)
+	}
+	data := map[string]any{"id": messageID, "channel_id": channelID}
+	if guildID != 0 {
+		data["guild_id"] = guildID
+	}
+	return s.Dispatch("MESSAGE_DELETE", data)
+}
+
+// Dispatch sends an event of the type with the data to every gateway session that is ready.
+func (s *Server) Dispatch(eventType string, data any) error {
+	s.mu.Lock()
+	sessions := slices.Clone(s.sessions)
+	s.mu.Unlock()
+	for _, ss := range sessions {
+		if !ss.isReady() {
+			continue
+		}
+		if err := ss.dispatch(eventType, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// --- REST API ---
+
+// record records the REST requests before handling them.
+func (s *Server) record(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if path, ok := strings.CutPrefix(r.URL.Path, apiPrefix); ok {
+			body, err := readPayload(r)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, 50109, err.Error())
+				return
+			}
+			s.mu.Lock()
+			s.requests = append(s.requests, Request{Method: r.Method, Path: path, Body: string(body)})
+			s.mu.Unlock()
+			r.Body = io.NopCloser(strings.NewReader(string(body)))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) getGateway(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"url":    s.GatewayURL(),
+		"shards": 1,
+		"session_start_limit": map[string]int{
+			"total": 1000, "remaining": 1000, "reset_after": 0, "max_concurrency": 1,
+		},
+	})
+}
+
+func (s *Server) getChannel(w http.ResponseWriter, r *http.Request) {
+	id, ch, ok := s.lookupChannel(w, r)
+	if !ok {
+		return
+	}
+	data := map[string]any{"id": id, "type": ch.Type}
+	if ch.GuildID != 0 {
+		data["guild_id"] = ch.GuildID
+	}
+	if ch.ParentID != 0 {
+		data["parent_id"] = ch.ParentID
+	}
+	writeJSON(w, http.StatusOK, data)
+}
+
+func (s *Server) getMessages(w http.ResponseWriter, r *http.Request) {
+	id, _, ok := s.lookupChannel(w, r)
+	if !ok {
+		return
+	}
+	after, _ := snowflake.Parse(r.URL.Query().Get("after"))
+	limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = 50
+	}
+	s.mu.Lock()
+	messages := slices.DeleteFunc(slices.Clone(s.messages[id]), func(m discord.Message) bool { return m.ID <= after })
+	s.mu.Unlock()
+	page := messages[:min(limit, len(messages))]
+	slices.Reverse(page) // newest first
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) getMessage(w http.ResponseWriter, r *http.Request) {
+	channelID, _, ok := s.lookupChannel(w, r)
+	if !ok {
+		return
+	}
+	messageID, _ := snowflake.Parse(r.PathValue("message"))
+	s.mu.Lock()
+	i, found := s.findMessage(channelID, messageID)
+	var m discord.Message
+	if found {
+		m = s.messages[channelID][i]
+	}
+	s.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, 10008, "Unknown Message")
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (s *Server) createMessage(w http.ResponseWriter, r *http.Request) {
+	channelID, _, ok := s.lookupChannel(w, r)
+	if !ok {
+		return
+	}
+	var create discord.MessageCreate
+	if err := json.NewDecoder(r.Body).Decode(&create); err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+	s.mu.Lock()
+	m := s.newMessage(channelID, s.self, create.Content)
+	if ref := create.MessageReference; ref != nil && ref.MessageID != nil {
+		if _, found := s.findMessage(channelID, *ref.MessageID); !found {
+			s.mu.Unlock()
+			writeError(w, http.StatusBadRequest, 50035, "Unknown message")
+			return
+		}
+		m.Type = discord.MessageTypeReply
+		m.MessageReference = &discord.MessageReference{MessageID: ref.MessageID, ChannelID: &channelID, GuildID: m.GuildID}
+	}
+	s.messages[channelID] = append(s.messages[channelID], m)
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (s *Server) updateMessage(w http.ResponseWriter, r *http.Request) {
+	channelID, _, ok := s.lookupChannel(w, r)
+	if !ok {
+		return
+	}
+	messageID, _ := snowflake.Parse(r.PathValue("message"))
+	var update discord.MessageUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+	s.mu.Lock()
+	i, found := s.findMessage(channelID, messageID)
+	var m discord.Message
+	if found {
+		if update.Content != nil {
+			s.messages[channelID][i].Content = *update.Content
+		}
+		now := time.Now()
+		s.messages[channelID][i].EditedTimestamp = &now
+		m = s.messages[channelID][i]
+	}
+	s.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, 10008, "Unknown Message")
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (s *Server) deleteMessage(w http.ResponseWriter, r *http.Request) {
+	channelID, _, ok := s.lookupChannel(w, r)
+	if !ok {
+		return
+	}
+	messageID, _ := snowflake.Parse(r.PathValue("message"))
+	s.mu.Lock()
+	i, found := s.findMessage(channelID, messageID)
+	if found {
+		s.messages[channelID] = slices.Delete(s.messages[channelID], i, i+1)
+	}
+	s.mu.Unlock()
+	if !found {
+		writeError(w, http.StatusNotFound, 10008, "Unknown Message")
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) noContent(w http.ResponseWriter, _ *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) getMember(w http.ResponseWriter, r *http.Request) {
+	guildID, ok := s.lookupGuild(w, r)
+	if !ok {
+		return
+	}
+	if userID, _ := snowflake.Parse(r.PathValue("user")); userID != s.self.ID {
+		writeError(w, http.StatusNotFound, 10007, "Unknown Member")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.member(guildID))
+}
+
+func (s *Server) updateCurrentMember(w http.ResponseWriter, r *http.Request) {
+	guildID, ok := s.lookupGuild(w, r)
+	if !ok {
+		return
+	}
+	var update discord.CurrentMemberUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		writeError(w, http.StatusBadRequest, 50109, err.Error())
+		return
+	}
+	s.mu.Lock()
+	s.nicks[guildID] = update.Nick
+	s.mu.Unlock()
+	writeJSON(w, http.StatusOK, s.member(guildID))
+}
+
+// guildCreate returns the `GUILD_CREATE` data of the guild, whose members include the bot like on Discord.
+func (s *Server) guildCreate(id snowflake.ID) map[string]any {
+	s.mu.Lock()
+	channels := make([]map[string]any, 0)
+	for channelID, ch := range s.channels {
+		if ch.GuildID == id {
+			channels = append(channels, map[string]any{"id": channelID, "type": ch.Type, "guild_id": ch.GuildID})
+		}
+	}
+	s.mu.Unlock()
+	return map[string]any{
+		"id":           id,
+		"name":         "guild " + id.String(),
+		"channels":     channels,
+		"members":      []any{s.member(id)},
+		"roles":        []any{},
+		"threads":      []any{},
+		"member_count": 1,
+	}
+}
+
+// member returns the bot member in the guild.
+func (s *Server) member(guildID snowflake.ID) map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	member := map[string]any{"user": s.self, "roles": []string{}, "joined_at": time.Unix(0, 0).UTC()}
+	if nick, ok := s.nicks[guildID]; ok {
+		member["nick"] = nick
+	}
+	return member
+}
+
+// lookupChannel returns the channel in the request path, or writes an error if it is unknown.
+func (s *Server) lookupChannel(w http.ResponseWriter, r *http.Request) (snowflake.ID, channel, bool) {
+	id, _ := snowflake.Parse(r.PathValue("channel"))
+	s.mu.Lock()
+	ch, ok := s.channels[id]
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, 10003, "Unknown Channel")
+	}
+	return id, ch, ok
+}
+
+// lookupGuild returns the guild in the request path, or writes an error if it is unknown.
+func (s *Server) lookupGuild(w http.ResponseWriter, r *http.Request) (snowflake.ID, bool) {
+	id, _ := snowflake.Parse(r.PathValue("guild"))
+	s.mu.Lock()
+	ok := slices.Contains(s.guilds, id)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, 10004, "Unknown Guild")
+	}
+	return id, ok
+}
+
+// newMessage returns a new message in the channel. The caller must hold s.mu.
+func (s *Server) newMessage(channelID snowflake.ID, author discord.User, content string) discord.Message {
+	s.lastID = max(s.lastID+1, snowflake.New(time.Now()))
+	m := discord.Message{
+		ID:        s.lastID,
+		ChannelID: channelID,
+		Author:    author,
+		Content:   content,
+		CreatedAt: s.lastID.Time(),
+		Type:      discord.MessageTypeDefault,
+	}
+	if guildID := s.channels[channelID].GuildID; guildID != 0 {
+		m.GuildID = &guildID
+	}
+	return m
+}
+
+// findMessage finds the message in the channel. The caller must hold s.mu.
+func (s *Server) findMessage(channelID, messageID snowflake.ID) (int, bool) {
+	i := slices.IndexFunc(s.messages[channelID], func(m discord.Message) bool { return m.ID == messageID })
+	return i, i >= 0
+}
+
+// readPayload returns the JSON payload of the request.
+func readPayload(r *http.Request) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			return nil, err
+		}
+		return []byte(r.FormValue("payload_json")), nil
+	}
+	return io.ReadAll(r.Body)
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a Discord JSON error response.
+func writeError(w http.ResponseWriter, status, code int, message string) {
+	writeJSON(w, status, map[string]any{"code": code, "message": message})
+}
+
+// poll calls f until it succeeds or ctx is done.
+func poll[T any](ctx context.Context, f func() (T, bool)) (T, error) {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		if v, ok := f(); ok {
+			return v, nil
+		}
+		select {
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// --- Gateway ---
+
+// upgrader upgrades gateway connections.
+var upgrader = websocket.Upgrader{}
+
+// session is a gateway connection.
+type session struct {
+	server *Server
+	conn   *websocket.Conn
+
+	mu         sync.Mutex
+	seq        int
+	ready      bool
+	heartbeats int
+}
+
+// gatewayMessage is a gateway payload.
+type gatewayMessage struct {
+	Op int             `json:"op"`
+	D  json.RawMessage `json:"d"`
+	S  int             `json:"s,omitempty"`
+	T  string          `json:"t,omitempty"`
+}
+
+// heartbeatInterval is the heartbeat interval sent in Hello.
+// It is short so that tests can wait for the first heartbeat with Server.WaitHeartbeat before closing the client.
+const heartbeatInterval = 500 * time.Millisecond
+
+// Gateway opcodes used by the emulator.
+const (
+	opDispatch       = 0
+	opHeartbeat      = 1
+	opIdentify       = 2
+	opPresenceUpdate = 3
+	opHello          = 10
+	opHeartbeatACK   = 11
+)
+
+// serveGateway serves a gateway session: Hello, then Ready and `GUILD_CREATE` for each guild on Identify.
+func (s *Server) serveGateway(w http.ResponseWriter, r *http.Request) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	ss := &session{server: s, conn: conn}
+	s.mu.Lock()
+	s.sessions = append(s.sessions, ss)
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		s.sessions = slices.DeleteFunc(s.sessions, func(other *session) bool { return other == ss })
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+	if err := ss.send(opHello, map[string]int64{"heartbeat_interval": heartbeatInterval.Milliseconds()}, ""); err != nil {
+		return
+	}
+	for {
+		var m gatewayMessage
+		if err := conn.ReadJSON(&m); err != nil {
+			return
+		}
+		switch m.Op {
+		case opHeartbeat:
+			if err = ss.send(opHeartbeatACK, nil, ""); err == nil {
+				ss.mu.Lock()
+				ss.heartbeats++
+				ss.mu.Unlock()
+			}
+		case opIdentify:
+			err = ss.identify(m.D)
+		case opPresenceUpdate:
+			s.mu.Lock()
+			s.presence = append(s.presence, m.D)
+			s.mu.Unlock()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// identify answers Identify with Ready and `GUILD_CREATE` for each guild.
+func (ss *session) identify(data json.RawMessage) error {
+	var identify struct {
+		Shard [2]int `json:"shard"`
+	}
+	if err := json.Unmarshal(data, &identify); err != nil {
+		return err
+	}
+	s := ss.server
+	s.mu.Lock()
+	guilds := slices.Clone(s.guilds)
+	s.mu.Unlock()
+	unavailable := make([]map[string]any, 0, len(guilds))
+	for _, id := range guilds {
+		unavailable = append(unavailable, map[string]any{"id": id, "unavailable": true})
+	}
+	ready := map[string]any{
+		"v":                  10,
+		"user":               s.self,
+		"guilds":             unavailable,
+		"session_id":         "emulated",
+		"resume_gateway_url": s.GatewayURL(),
+		"shard":              identify.Shard,
+		"application":        map[string]any{"id": s.self.ID, "flags": 0},
+	}
+	if err := ss.dispatch("READY", ready); err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	ss.ready = true
+	ss.mu.Unlock()
+	for _, id := range guilds {
+		if err := ss.dispatch("GUILD_CREATE", s.guildCreate(id)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isReady returns true once Ready has been sent.
+func (ss *session) isReady() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.ready
+}
+
+// isBeating returns true once a heartbeat has been acknowledged after Ready.
+func (ss *session) isBeating() bool {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	return ss.ready && ss.heartbeats > 0
+}
+
+// dispatch sends an event with the next sequence number.
+func (ss *session) dispatch(eventType string, data any) error {
+	return ss.send(opDispatch, data, eventType)
+}
+
+// send writes a gateway payload.
+func (ss *session) send(op int, data any, eventType string) error {
+	d, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+	m := gatewayMessage{Op: op, D: d, T: eventType}
+	if op == opDispatch {
+		ss.seq++
+		m.S = ss.seq
+	}
+	return ss.conn.WriteJSON(m)
+}
@@ -0,0 +1,49 @@
+package future
+
+import (
+	"context"
+)
+
+// All returns a Future that runs the Futures in parallel and resolves to their values in the given order.
+// It fails with the first error, canceling the context of the other Futures.
+func All[T any](futures ...Future[T]) Future[[]T] {
+	return NewDeferred(func(ctx context.Context) ([]T, error) {
+		ctx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		values := make([]T, len(futures))
+		results := start(ctx, futures)
+		for range futures {
+			r := <-results
+			if r.Err != nil {
+				return nil, r.Err
+			}
+			values[r.index] = r.Value
+		}
+		return values, nil
+	})
+}
+
+// indexedResult is the Result of the Future at index.
+type indexedResult[T any] struct {
+	Result[T]
+	index int
+}
+
+// start runs the Futures in parallel and sends their results as they complete.
+// The channel is buffered for all results, so the Futures never block on sending after the caller returns.
+func start[T any](ctx context.Context, futures []Future[T]) <-chan indexedResult[T] {
+	ch := make(chan indexedResult[T], len(futures))
+	for i, f := range futures {
+		go func() {
+			ch <- indexedResult[T]{Result: f.result(ctx), index: i}
+		}()
+	}
+	return ch
+}
+
+// result calls f and returns its result, converting a panic into an error.
+func (f Future[T]) result(ctx context.Context) (r Result[T]) {
+	defer r.recover()
+	r.Value, r.Err = f(ctx)
+	return r
+}
@@ -0,0 +1,70 @@
+package future
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// makeCanceledFuture returns a Future that records whether its context was canceled before the delay.
+func makeCanceledFuture(canceled *atomic.Bool, delay time.Duration) Future[int] {
+	return func(ctx context.Context) (int, error) {
+		select {
+		case <-ctx.Done():
+			canceled.Store(true)
+			return 0, ctx.Err()
+		case <-time.After(delay):
+			return 0, nil
+		}
+	}
+}
+
+func TestAll(t *testing.T) {
+	ctx := context.Background()
+	errTest := errors.New("test error")
+
+	t.Run("values in order", func(t *testing.T) {
+		v, err := All(
+			makeSuccessFuture(1, 15*time.Millisecond),
+			makeSuccessFuture(2, 5*time.Millisecond),
+			makeSuccessFuture(3, 0),
+		)(ctx)
+		assert.NilError(t, err)
+		assert.DeepEqual(t, v, []int{1, 2, 3})
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		v, err := All[int]()(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, len(v), 0)
+	})
+
+	t.Run("fails fast and cancels others", func(t *testing.T) {
+		var canceled atomic.Bool
+		start := time.Now()
+		_, err := All(makeCanceledFuture(&canceled, time.Second), makeErrorFuture(errTest, 5*time.Millisecond))(ctx)
+		assert.ErrorIs(t, err, errTest)
+		assert.Assert(t, time.Since(start) < 500*time.Millisecond)
+		assert.Assert(t, waitFor(canceled.Load), "other future not canceled")
+	})
+
+	t.Run("panic", func(t *testing.T) {
+		_, err := All(NewValue(1), func(context.Context) (int, error) { panic("panic in future") })(ctx)
+		assert.Error(t, err, "panic in future")
+	})
+}
+
+// waitFor polls cond for up to a second, since canceled futures observe the cancellation asynchronously.
+func waitFor(cond func() bool) bool {
+	for range 100 {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
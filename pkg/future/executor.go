@@ -0,0 +1,100 @@
+package future
+
+import "context"
+
+// Executor bounds the number of Tasks running at once, like a pool of workers with a queue.
+// Tasks beyond the workers wait in the queue; submitting more Tasks than the workers and the queue can hold
+// blocks until one of them completes. Queued Tasks whose context ends before they start are never run.
+// An Executor is safe for concurrent use and can be shared by multiple calls of New and Await.
+type Executor struct {
+	// running holds a token for each Task running.
+	running chan struct{}
+	// pending holds a token for each Task running or queued.
+	pending chan struct{}
+}
+
+// NewExecutor creates an Executor running at most workers Tasks at once, with up to queue Tasks waiting.
+// workers is at least 1.
+func NewExecutor(workers, queue int) *Executor {
+	workers = max(workers, 1)
+	return &Executor{
+		running: make(chan struct{}, workers),
+		pending: make(chan struct{}, workers+max(queue, 0)),
+	}
+}
+
+// Running returns the number of Tasks running on the Executor.
+func (e *Executor) Running() int {
+	return len(e.running)
+}
+
+// Queued returns the number of Tasks waiting for a worker of the Executor.
+func (e *Executor) Queued() int {
+	// The counts are read one after the other and may be momentarily inconsistent.
+	return max(len(e.pending)-len(e.running), 0)
+}
+
+// Saturated returns true if the workers and the queue of the Executor are full,
+// so that submitting another Task blocks until one of them completes.
+func (e *Executor) Saturated() bool {
+	return len(e.pending) == cap(e.pending)
+}
+
+// Option configures New and Await.
+type Option func(*config)
+
+type config struct {
+	executor *Executor
+}
+
+// WithExecutor runs the Tasks on the Executor instead of starting a goroutine for each of them right away.
+func WithExecutor(e *Executor) Option {
+	return func(c *config) {
+		c.executor = e
+	}
+}
+
+func newConfig(opts []Option) config {
+	var c config
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
+}
+
+// schedule starts the task, on the Executor if not nil, and returns the receiver of its result.
+// It waits for room in the queue of the Executor, and fails with ctx.Err() if ctx ends before that.
+func schedule[T any](ctx context.Context, e *Executor, task func(context.Context) (T, error)) func(context.Context) (T, error) {
+	if e != nil {
+		select {
+		case e.pending <- struct{}{}:
+		case <-ctx.Done():
+			return NewError[T](ctx.Err())
+		}
+		task = runOn(e, task)
+	}
+	runner, receiver := makeRunnerAndReceiver(task)
+	go runner(ctx)
+	return receiver
+}
+
+// runOn returns a task that waits for a worker of the Executor before calling the task,
+// and releases its slots afterwards. The task is not called if ctx ends while it is queued.
+func runOn[T any](e *Executor, task func(context.Context) (T, error)) func(context.Context) (T, error) {
+	return func(ctx context.Context) (T, error) {
+		defer func() { <-e.pending }()
+		select {
+		case e.running <- struct{}{}:
+			defer func() { <-e.running }()
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+		// Both cases may be ready at once; do not start a task whose context has already ended.
+		if err := ctx.Err(); err != nil {
+			var zero T
+			return zero, err
+		}
+		return task(ctx)
+	}
+}
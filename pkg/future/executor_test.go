@@ -0,0 +1,143 @@
+package future
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// makeCountingFuture returns a Future that records the number of Futures running at once in peak.
+func makeCountingFuture(val int, delay time.Duration, running, peak *int32) Future[int] {
+	return func(ctx context.Context) (int, error) {
+		n := atomic.AddInt32(running, 1)
+		defer atomic.AddInt32(running, -1)
+		for {
+			p := atomic.LoadInt32(peak)
+			if n <= p || atomic.CompareAndSwapInt32(peak, p, n) {
+				break
+			}
+		}
+		return makeSuccessFuture(val, delay)(ctx)
+	}
+}
+
+func TestAwait_WithExecutor(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("bounded concurrency in order", func(t *testing.T) {
+		var running, peak int32
+		num := 12
+		futures := make([]Future[int], num)
+		for i := range num {
+			futures[i] = makeCountingFuture(i, time.Duration(num-i)*time.Millisecond, &running, &peak)
+		}
+		var got []int
+		for res := range Await(ctx, slices.Values(futures), WithExecutor(NewExecutor(3, 2))) {
+			assert.NilError(t, res.Err)
+			got = append(got, res.Value)
+		}
+		assert.DeepEqual(t, got, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11})
+		assert.Assert(t, atomic.LoadInt32(&peak) <= 3, "peak %d", peak)
+	})
+
+	t.Run("queued tasks are not started after cancel", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer cancel()
+		var started int32
+		num := 5
+		futures := make([]Future[int], num)
+		for i := range num {
+			futures[i] = func(ctx context.Context) (int, error) {
+				atomic.AddInt32(&started, 1)
+				return makeSuccessFuture(i, 50*time.Millisecond)(ctx)
+			}
+		}
+		var count int
+		for res := range Await(ctx, slices.Values(futures), WithExecutor(NewExecutor(1, 10))) {
+			assert.ErrorIs(t, res.Err, context.DeadlineExceeded)
+			count++
+		}
+		assert.Equal(t, count, num)
+		assert.Equal(t, atomic.LoadInt32(&started), int32(1))
+	})
+
+	t.Run("shared executor", func(t *testing.T) {
+		var running, peak int32
+		e := NewExecutor(2, 0)
+		done := make(chan struct{})
+		for range 3 {
+			go func() {
+				defer func() { done <- struct{}{} }()
+				futures := []Future[int]{
+					makeCountingFuture(1, 5*time.Millisecond, &running, &peak),
+					makeCountingFuture(2, 5*time.Millisecond, &running, &peak),
+				}
+				for res := range Await(ctx, slices.Values(futures), WithExecutor(e)) {
+					assert.Check(t, res.Err)
+				}
+			}()
+		}
+		for range 3 {
+			<-done
+		}
+		assert.Assert(t, atomic.LoadInt32(&peak) <= 2, "peak %d", peak)
+	})
+}
+
+func TestNew_WithExecutor(t *testing.T) {
+	ctx := context.Background()
+	e := NewExecutor(1, 0)
+	started, release := make(chan struct{}), make(chan struct{})
+	first := New(ctx, func(context.Context) (int, error) {
+		close(started)
+		<-release
+		return 1, nil
+	}, WithExecutor(e))
+
+	t.Run("counts", func(t *testing.T) {
+		<-started
+		assert.Equal(t, e.Running(), 1)
+		assert.Equal(t, e.Queued(), 0)
+		assert.Assert(t, e.Saturated())
+	})
+
+	t.Run("blocks while the queue is full", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		called := false
+		_, err := New(ctx, func(context.Context) (int, error) {
+			called = true
+			return 2, nil
+		}, WithExecutor(e))(ctx)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+		assert.Assert(t, !called)
+	})
+
+	close(release)
+	v, err := first(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, v, 1)
+
+	t.Run("counts after completion", func(t *testing.T) {
+		assert.Equal(t, e.Running(), 0)
+		assert.Assert(t, !e.Saturated())
+	})
+
+	t.Run("runs once there is room", func(t *testing.T) {
+		v, err := New(ctx, func(context.Context) (int, error) { return 3, nil }, WithExecutor(e))(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, v, 3)
+	})
+
+	t.Run("panic releases the worker", func(t *testing.T) {
+		_, err := New(ctx, func(context.Context) (int, error) { panic("panic in task") }, WithExecutor(e))(ctx)
+		assert.Error(t, err, "panic in task")
+		v, err := New(ctx, func(context.Context) (int, error) { return 4, nil }, WithExecutor(e))(ctx)
+		assert.NilError(t, err)
+		assert.Equal(t, v, 4)
+	})
+}
@@ -19,10 +19,9 @@ type Future[T any] func(context.Context) (T, error)
 
 // New creates a Future from a Task and starts asynchronous execution immediately.
 // The returned Future will return the result (value or error) when called, and always returns the same result for all calls.
-func New[T any](ctx context.Context, task Task[T]) Future[T] {
-	runner, receiver := makeRunnerAndReceiver(task)
-	go runner(ctx)
-	return receiver
+// With WithExecutor, the Task is queued on the Executor instead, and New blocks while the queue is full.
+func New[T any](ctx context.Context, task Task[T], opts ...Option) Future[T] {
+	return schedule(ctx, newConfig(opts).executor, task)
 }
 
 // NewDeferred creates a Future from a Task.
@@ -113,16 +112,17 @@ func (r *Result[T]) recover() {
 	}
 }
 
-// Await runs multiple Futures in parallel and yields their results (value or error) as they complete.
+// Await runs multiple Futures in parallel and yields their results (value or error) in the order of the Futures,
+// so a slow Future delays the results of the later ones; use AwaitUnordered to get results as they complete.
 // Even if ctx is canceled, all results are eventually yielded (with error) for each Future.
-func Await[T any](ctx context.Context, futures iter.Seq[Future[T]]) iter.Seq[Result[T]] {
+// With WithExecutor, the Futures are run on the Executor, which bounds how many of them run at once.
+func Await[T any](ctx context.Context, futures iter.Seq[Future[T]], opts ...Option) iter.Seq[Result[T]] {
+	executor := newConfig(opts).executor
 	receiverCh := make(chan func(context.Context) (T, error), runtime.NumCPU())
 	go func() {
 		defer close(receiverCh)
 		for f := range futures {
-			runner, receiver := makeRunnerAndReceiver(f)
-			receiverCh <- receiver
-			go runner(ctx)
+			receiverCh <- schedule(ctx, executor, f)
 		}
 	}()
 	return slices.Values(slices.Collect(func(yield func(Result[T]) bool) {
@@ -135,3 +135,50 @@ func Await[T any](ctx context.Context, futures iter.Seq[Future[T]]) iter.Seq[Res
 		}
 	}))
 }
+
+// AwaitUnordered runs multiple Futures in parallel and yields the index of each Future with its result
+// (value or error) as soon as it completes. The Futures start when the sequence is iterated.
+// Even if ctx is canceled, all results are eventually yielded (with error) for each Future.
+// Stopping the iteration early leaves the remaining Futures running, but their results are discarded.
+func AwaitUnordered[T any](ctx context.Context, futures iter.Seq[Future[T]], opts ...Option) iter.Seq2[int, Result[T]] {
+	executor := newConfig(opts).executor
+	return func(yield func(int, Result[T]) bool) {
+		results := make(chan indexedResult[T])
+		stop := make(chan struct{})
+		defer close(stop)
+		var wg sync.WaitGroup
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			i := 0
+			for f := range futures {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				receiver := schedule(ctx, executor, f)
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					r := indexedResult[T]{index: i}
+					r.Value, r.Err = receiver(ctx)
+					select {
+					case results <- r:
+					case <-stop:
+					}
+				}(i)
+				i++
+			}
+		}()
+		go func() {
+			wg.Wait()
+			close(results)
+		}()
+		for r := range results {
+			if !yield(r.index, r.Result) {
+				return
+			}
+		}
+	}
+}
@@ -199,6 +199,70 @@ func TestAwait_Timeout_Multi(t *testing.T) {
 }
 
 // --- Future (single) method tests ---
+// --- AwaitUnordered behavior tests ---
+
+func TestAwaitUnordered_CompletionOrder(t *testing.T) {
+	ctx := context.Background()
+	futures := []Future[int]{
+		makeSuccessFuture(0, 60*time.Millisecond),
+		makeSuccessFuture(1, 0),
+		makeErrorFuture(errors.New("test error"), 30*time.Millisecond),
+	}
+	var indices []int
+	for i, res := range AwaitUnordered(ctx, slices.Values(futures)) {
+		indices = append(indices, i)
+		if i == 2 {
+			assert.Error(t, res.Err, "test error")
+		} else {
+			assert.NilError(t, res.Err)
+			assert.Equal(t, res.Value, i)
+		}
+	}
+	assert.DeepEqual(t, indices, []int{1, 2, 0})
+}
+
+func TestAwaitUnordered_ContextCancel(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	num := 5
+	futures := make([]Future[int], num)
+	for i := range num {
+		futures[i] = makeSuccessFuture(i, time.Second)
+	}
+	seen := make(map[int]bool)
+	for i, res := range AwaitUnordered(ctx, slices.Values(futures), WithExecutor(NewExecutor(2, 1))) {
+		assert.ErrorIs(t, res.Err, context.DeadlineExceeded)
+		seen[i] = true
+	}
+	assert.Equal(t, len(seen), num)
+}
+
+func TestAwaitUnordered_Break(t *testing.T) {
+	ctx := context.Background()
+	futures := []Future[int]{
+		makeSuccessFuture(0, 0),
+		makeSuccessFuture(1, 20*time.Millisecond),
+		makeSuccessFuture(2, 20*time.Millisecond),
+	}
+	var count int
+	for i, res := range AwaitUnordered(ctx, slices.Values(futures)) {
+		assert.NilError(t, res.Err)
+		assert.Equal(t, i, 0)
+		count++
+		break
+	}
+	assert.Equal(t, count, 1)
+}
+
+func TestAwaitUnordered_PanicFuture(t *testing.T) {
+	ctx := context.Background()
+	futures := []Future[int]{func(context.Context) (int, error) { panic("panic in future") }}
+	for i, res := range AwaitUnordered(ctx, slices.Values(futures)) {
+		assert.Equal(t, i, 0)
+		assert.Error(t, res.Err, "panic in future")
+	}
+}
+
 func TestFuture_Await(t *testing.T) {
 	ctx := context.Background()
 	want := 42
@@ -0,0 +1,322 @@
+package irc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/logging"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/message"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/xiter"
+)
+
+const (
+	// maxPasteBytes limits the size of the standard input fetched from a paste URL.
+	maxPasteBytes = 1 << 20
+	// maxRedirects limits the redirects followed when fetching a paste, like the default of net/http.
+	maxRedirects = 10
+)
+
+// errNotSupported is returned when editing or deleting replies, which IRC does not support.
+var errNotSupported = errors.New("not supported on IRC")
+
+// onPrivmsg answers a PRIVMSG addressed to the client, either `nick: args` in a channel or any private message.
+func (c *Client) onPrivmsg(m Message) {
+	target, text := m.Param(0), m.Param(1)
+	nick := c.currentNick()
+	line, addressed := cutAddress(text, nick)
+	conversation := &ircConversation{client: c, target: m.Nick()}
+	incoming := message.Incoming{Channel: message.ChannelDirect, BotName: nick}
+	if isChannel(target) {
+		if !addressed {
+			return
+		}
+		conversation.target, conversation.prefix = target, m.Nick()+": "
+		incoming.Channel = message.ChannelShared
+	} else if !strings.EqualFold(target, nick) {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.draining {
+		return
+	}
+	c.jobs.Add(1)
+	go func() {
+		defer c.jobs.Done()
+		ctx, cancel := context.WithCancel(c.abortCtx)
+		defer cancel()
+		logger := slog.Default().With(
+			slog.String("job.id", logging.NewJobID()),
+			slog.String("irc.target", target),
+			slog.String("irc.nick", m.Nick()),
+		)
+		ctx = logging.WithLogger(ctx, logger)
+		if err := c.answer(ctx, conversation, incoming, line); err != nil {
+			logger.Error("Failed to answer IRC message", slog.Any("err", err))
+		}
+	}()
+}
+
+// answer executes the command line and sends the results.
+func (c *Client) answer(ctx context.Context, conversation *ircConversation, incoming message.Incoming, line string) error {
+	args, input, pasteURL := splitInput(line)
+	if strings.TrimSpace(args) == "" && input == nil && pasteURL == "" {
+		return conversation.say(ctx, fmt.Sprintf("usage: %s: [args] [<<< stdin with \\n escapes | < paste URL]", incoming.BotName))
+	}
+	if pasteURL != "" {
+		var err error
+		if input, err = c.fetchPaste(ctx, pasteURL); err != nil {
+			return conversation.say(ctx, err.Error())
+		}
+	}
+	incoming.Addressed = true
+	incoming.CommandLines = []string{args}
+	incoming.Input = input
+	results := future.AwaitUnordered(ctx, message.ExecuteIncoming(ctx, c.options, conversation, incoming, c.futureOpts...))
+	results = xiter.MapValues(results, func(r future.Result[*message.ExecutionResult]) future.Result[*message.ExecutionResult] {
+		if r.Err != nil {
+			// Unlike Discord, failures are answered since there is no reply to keep.
+			return future.Result[*message.ExecutionResult]{Value: &message.ExecutionResult{Content: r.Err.Error()}}
+		}
+		return r
+	})
+	// Lines are sent even if the job is aborted, so that the sender learns that the command was cancelled.
+	return message.SyncReplies(context.WithoutCancel(ctx), conversation, results, xiter.SeqOf[message.Reply](), xiter.SeqOf[message.Reply]())
+}
+
+// cutAddress returns the text after `nick:` or `nick,` and true if the text is addressed to the nickname.
+func cutAddress(text, nick string) (string, bool) {
+	if len(text) > len(nick) && strings.EqualFold(text[:len(nick)], nick) && strings.ContainsRune(":,", rune(text[len(nick)])) {
+		return strings.TrimSpace(text[len(nick)+1:]), true
+	}
+	return strings.TrimSpace(text), false
+}
+
+// splitInput splits a command line into the arguments and the standard input given by
+// `<<< text`, where `\n`, `\t` and `\\` are unescaped and a newline is appended like a shell here-string,
+// or the URL given by a trailing `< URL`.
+func splitInput(line string) (args string, input []byte, pasteURL string) {
+	if before, after, ok := strings.Cut(line, "<<<"); ok {
+		return strings.TrimSpace(before), []byte(unescape(strings.TrimPrefix(after, " ")) + "\n"), ""
+	}
+	if i := strings.LastIndex(line, "<"); i >= 0 {
+		url := strings.TrimSpace(line[i+1:])
+		if (strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://")) && !strings.ContainsAny(url, " \t") {
+			return strings.TrimSpace(line[:i]), nil, url
+		}
+	}
+	return line, nil, ""
+}
+
+// unescape replaces the escape sequences `\n`, `\t` and `\\` in text.
+func unescape(text string) string {
+	return strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\t`, "\t").Replace(text)
+}
+
+// fetchPaste returns the content of the paste URL if it starts with one of PasteURLPrefixes.
+func (c *Client) fetchPaste(ctx context.Context, url string) ([]byte, error) {
+	if !c.pasteAllowed(url) {
+		return nil, fmt.Errorf("paste URL not allowed: %s", url)
+	}
+	client, err := c.httpClient()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch paste: %w", err)
+	}
+	// A redirect must not lead out of the allowed prefixes, e.g. through an open redirect of the paste service.
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if !c.pasteAllowed(req.URL.String()) {
+			return fmt.Errorf("paste URL not allowed: %s", req.URL)
+		}
+		if len(via) >= maxRedirects {
+			return fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		return nil
+	}
+	ctx, cancel := c.options.ContextWithRestTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch paste: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch paste: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch paste: %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxPasteBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch paste: %w", err)
+	}
+	if len(body) > maxPasteBytes {
+		return nil, fmt.Errorf("paste is larger than %d bytes", maxPasteBytes)
+	}
+	return body, nil
+}
+
+// pasteAllowed returns true if the URL starts with one of PasteURLPrefixes.
+func (c *Client) pasteAllowed(url string) bool {
+	return slices.ContainsFunc(c.options.IRC.PasteURLPrefixes, func(prefix string) bool { return strings.HasPrefix(url, prefix) })
+}
+
+// upload posts the content to UploadURL as the `file` field of a multipart form,
+// and returns the first line of the response, which is the URL of the upload.
+func (c *Client) upload(ctx context.Context, name string, content []byte) (string, error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := fw.Write(content); err != nil {
+		return "", err
+	}
+	if err := mw.Close(); err != nil {
+		return "", err
+	}
+	ctx, cancel := c.options.ContextWithRestTimeout(ctx)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.options.IRC.UploadURL, &body)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	client, err := c.httpClient()
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode/100 != 2 {
+		return "", fmt.Errorf("upload failed: %s", resp.Status)
+	}
+	url, _, _ := strings.Cut(strings.TrimSpace(string(respBody)), "\n")
+	return url, nil
+}
+
+// httpClient returns the HTTP client fetching pastes and uploading outputs through the proxy and CA bundle
+// of the options.
+func (c *Client) httpClient() (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	proxyURL, err := c.options.ProxyURL()
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	config, err := c.options.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config != nil {
+		transport.TLSClientConfig = config
+	}
+	return &http.Client{Transport: transport}, nil
+}
+
+// ircConversation implements message.Conversation for a command in a channel or a private message.
+type ircConversation struct {
+	client *Client
+	target string
+	// prefix addresses the replies in a channel to the sender.
+	prefix string
+}
+
+func (c *ircConversation) Typing(context.Context) error {
+	return nil
+}
+
+// Send sends the first IRC_MAX_LINES lines of the result. If the result has more lines or files,
+// they are uploaded to IRC_UPLOAD_URL and their URLs are sent instead.
+func (c *ircConversation) Send(ctx context.Context, r *message.ExecutionResult) (message.Reply, error) {
+	lines := resultLines(r.Content)
+	maxLines := c.client.options.IRC.MaxLines
+	// Outputs longer than the embed limits are already complete in their files.
+	if len(lines) > maxLines && !r.HasOutputFiles() {
+		url := fmt.Sprintf("%d more lines", len(lines)-maxLines)
+		if c.client.options.IRC.UploadURL != "" {
+			content := []byte(strings.Join(lines, "\n") + "\n")
+			if uploaded, err := c.client.upload(ctx, "output.txt", content); err == nil {
+				url = uploaded
+			} else {
+				logging.FromContext(ctx).Warn("Failed to upload output", slog.Any("err", err))
+			}
+		}
+		lines = append(lines[:maxLines:maxLines], fmt.Sprintf("[%s]", url))
+	} else if len(lines) > maxLines {
+		lines = lines[:maxLines]
+	}
+	for _, file := range r.Files {
+		if c.client.options.IRC.UploadURL == "" {
+			lines = append(lines, fmt.Sprintf("[%s omitted]", file.Name))
+			continue
+		}
+		content, err := io.ReadAll(file.Reader)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", file.Name, err)
+		}
+		url, err := c.client.upload(ctx, file.Name, content)
+		if err != nil {
+			logging.FromContext(ctx).Warn("Failed to upload file", slog.String("file", file.Name), slog.Any("err", err))
+			url = "upload failed"
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", file.Name, url))
+	}
+	for _, line := range lines {
+		if err := c.say(ctx, line); err != nil {
+			return nil, err
+		}
+	}
+	return ircReply{}, nil
+}
+
+// say sends a line to the target, addressed to the sender in a channel.
+func (c *ircConversation) say(ctx context.Context, line string) error {
+	return c.client.privmsg(ctx, c.target, c.prefix+line)
+}
+
+// ircReply implements message.Reply for lines sent on IRC, which can be neither edited nor deleted.
+type ircReply struct{}
+
+func (ircReply) ID() string {
+	return ""
+}
+
+func (ircReply) Edit(context.Context, *message.ExecutionResult) error {
+	return errNotSupported
+}
+
+func (ircReply) Delete(context.Context) error {
+	return errNotSupported
+}
+
+// resultLines converts the Markdown content of a result into plain text lines without code block fences.
+func resultLines(content string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		if line == "```" {
+			continue
+		}
+		lines = append(lines, strings.ReplaceAll(line, "```", ""))
+	}
+	return lines
+}
@@ -0,0 +1,310 @@
+// Package irc provides the IRC frontend, which answers `nick: args` lines in channels and private messages
+// by executing the target CLI in the same way as for Discord messages.
+package irc
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+)
+
+const (
+	// dialTimeout is the timeout of connecting to the server.
+	dialTimeout = 30 * time.Second
+	// minReconnectDelay and maxReconnectDelay bound the exponential backoff between reconnections.
+	minReconnectDelay = time.Second
+	maxReconnectDelay = time.Minute
+	// readTimeout is how long the connection may stay silent before it is considered dead.
+	// Servers send PING well within this period.
+	readTimeout = 5 * time.Minute
+	// sendBurst lines are sent at once, then one line per sendInterval,
+	// so that long outputs do not get the client disconnected for flooding.
+	sendBurst    = 4
+	sendInterval = time.Second
+)
+
+// errSASLFailed is returned when the server rejects the SASL authentication.
+var errSASLFailed = errors.New("SASL authentication failed")
+
+// Client is an IRC client answering the commands addressed to it.
+type Client struct {
+	options *options.Options
+	// futureOpts are passed to message.ExecuteIncoming, e.g. to share the Executor of the Discord bot.
+	futureOpts []future.Option
+
+	// mu guards the connection, the current nickname, draining and adding to jobs.
+	mu       sync.Mutex
+	conn     net.Conn
+	nick     string
+	draining bool
+	jobs     sync.WaitGroup
+	// limiter delays the outgoing PRIVMSGs.
+	limiter *tokenBucket
+	// abortCtx is cancelled when in-flight jobs must be aborted on shutdown.
+	abortCtx context.Context
+	abort    context.CancelFunc
+}
+
+// New creates a Client with the IRC options. The opts are passed to future.New starting each command,
+// e.g. future.WithExecutor to share the limit of concurrent executions with the Discord bot.
+func New(o *options.Options, opts ...future.Option) *Client {
+	abortCtx, abort := context.WithCancel(context.Background())
+	return &Client{
+		options:    o,
+		futureOpts: opts,
+		nick:       o.IRCNick(),
+		limiter:    &tokenBucket{burst: sendBurst, interval: sendInterval},
+		abortCtx:   abortCtx,
+		abort:      abort,
+	}
+}
+
+// Serve connects to the server and answers commands until ctx is done or Shutdown is called.
+// It reconnects with exponential backoff when the connection is lost.
+func (c *Client) Serve(ctx context.Context) {
+	delay := minReconnectDelay
+	for {
+		start := time.Now()
+		err := c.session(ctx)
+		if ctx.Err() != nil || c.isDraining() {
+			return
+		}
+		if time.Since(start) > maxReconnectDelay {
+			delay = minReconnectDelay
+		}
+		slog.Error("IRC connection lost", slog.String("server", c.options.IRC.Server), slog.Any("err", err), slog.Duration("retry", delay))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// Shutdown stops answering new commands and waits up to drainTimeout for in-flight jobs to reply.
+// Jobs still running after that are aborted. It then quits the server.
+func (c *Client) Shutdown(ctx context.Context, drainTimeout time.Duration) {
+	c.mu.Lock()
+	c.draining = true
+	c.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		c.jobs.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(drainTimeout)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+		c.abort()
+	case <-ctx.Done():
+		c.abort()
+	}
+	select {
+	case <-done:
+	case <-ctx.Done():
+		slog.Error("Failed to wait for aborted IRC jobs", slog.Any("err", ctx.Err()))
+	}
+	_ = c.send(Message{Command: "QUIT", Params: []string{"shutting down"}})
+	c.mu.Lock()
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+	c.mu.Unlock()
+}
+
+// isDraining returns true once shutdown has begun.
+func (c *Client) isDraining() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.draining
+}
+
+// session connects to the server, registers and handles messages until the connection is closed or ctx is done.
+func (c *Client) session(ctx context.Context) error {
+	conn, err := c.dial(ctx)
+	if err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.conn = conn
+	c.nick = c.options.IRCNick()
+	c.mu.Unlock()
+	stop := context.AfterFunc(ctx, func() {
+		_ = c.send(Message{Command: "QUIT", Params: []string{"shutting down"}})
+		_ = conn.Close()
+	})
+	defer stop()
+	defer conn.Close()
+
+	if c.options.IRC.SASLUsername != "" {
+		_ = c.send(Message{Command: "CAP", Params: []string{"REQ", "sasl"}})
+	}
+	_ = c.send(Message{Command: "NICK", Params: []string{c.currentNick()}})
+	_ = c.send(Message{Command: "USER", Params: []string{c.currentNick(), "0", "*", c.options.TargetCLI}})
+
+	reader := bufio.NewReader(conn)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(readTimeout))
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return errors.New("connection closed")
+			}
+			return err
+		}
+		if err := c.handle(ParseMessage(line)); err != nil {
+			return err
+		}
+	}
+}
+
+// dial connects to the server, using TLS with the CA bundle of the options if enabled.
+func (c *Client) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	if !c.options.IRC.TLS {
+		return dialer.DialContext(ctx, "tcp", c.options.IRC.Server)
+	}
+	config, err := c.options.TLSConfig()
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		config = &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+	return (&tls.Dialer{NetDialer: dialer, Config: config}).DialContext(ctx, "tcp", c.options.IRC.Server)
+}
+
+// handle handles a message from the server.
+func (c *Client) handle(m Message) error {
+	switch m.Command {
+	case "PING":
+		return c.send(Message{Command: "PONG", Params: m.Params})
+	case "CAP":
+		if m.Param(1) == "ACK" && strings.Contains(m.Param(2), "sasl") {
+			return c.send(Message{Command: "AUTHENTICATE", Params: []string{"PLAIN"}})
+		}
+		if m.Param(1) == "NAK" {
+			return errSASLFailed
+		}
+	case "AUTHENTICATE":
+		if m.Param(0) == "+" {
+			user, password := c.options.IRC.SASLUsername, c.options.IRC.SASLPassword
+			token := base64.StdEncoding.EncodeToString([]byte(user + "\x00" + user + "\x00" + password))
+			return c.send(Message{Command: "AUTHENTICATE", Params: []string{token}})
+		}
+	case "903": // RPL_SASLSUCCESS
+		return c.send(Message{Command: "CAP", Params: []string{"END"}})
+	case "902", "904", "905", "906": // ERR_NICKLOCKED, ERR_SASLFAIL, ERR_SASLTOOLONG, ERR_SASLABORTED
+		return fmt.Errorf("%w: %s", errSASLFailed, m.Param(len(m.Params)-1))
+	case "001": // RPL_WELCOME
+		c.mu.Lock()
+		c.nick = m.Param(0)
+		c.mu.Unlock()
+		slog.Info("Connected to IRC", slog.String("server", c.options.IRC.Server), slog.String("nick", m.Param(0)))
+		if len(c.options.IRC.Channels) > 0 {
+			return c.send(Message{Command: "JOIN", Params: []string{strings.Join(c.options.IRC.Channels, ",")}})
+		}
+	case "433": // ERR_NICKNAMEINUSE
+		c.mu.Lock()
+		c.nick += "_"
+		c.mu.Unlock()
+		return c.send(Message{Command: "NICK", Params: []string{c.currentNick()}})
+	case "NICK":
+		c.mu.Lock()
+		if strings.EqualFold(m.Nick(), c.nick) {
+			c.nick = m.Param(0)
+		}
+		c.mu.Unlock()
+	case "PRIVMSG":
+		c.onPrivmsg(m)
+	}
+	return nil
+}
+
+// currentNick returns the nickname of the client.
+func (c *Client) currentNick() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nick
+}
+
+// send sends a message to the server if connected.
+func (c *Client) send(m Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return net.ErrClosed
+	}
+	_, err := io.WriteString(c.conn, m.String()+"\r\n")
+	return err
+}
+
+// privmsg sends the text to the target, split into lines that fit the IRC limits and rate limited by the limiter.
+func (c *Client) privmsg(ctx context.Context, target, text string) error {
+	for _, line := range strings.Split(text, "\n") {
+		for _, piece := range splitText(line) {
+			if piece == "" {
+				piece = " "
+			}
+			if err := c.limiter.wait(ctx); err != nil {
+				return err
+			}
+			if err := c.send(Message{Command: "PRIVMSG", Params: []string{target, piece}}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tokenBucket allows a burst of lines, then one line per interval.
+type tokenBucket struct {
+	burst    int
+	interval time.Duration
+
+	mu sync.Mutex
+	// next is when the bucket will have a token again after the lines already allowed, if it were empty.
+	next time.Time
+}
+
+// wait waits until a line may be sent, or ctx is done without taking a token.
+func (b *tokenBucket) wait(ctx context.Context) error {
+	b.mu.Lock()
+	now := time.Now()
+	if b.next.Before(now) {
+		b.next = now
+	}
+	delay := b.next.Sub(now) - time.Duration(b.burst-1)*b.interval
+	b.next = b.next.Add(b.interval)
+	b.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		b.mu.Lock()
+		b.next = b.next.Add(-b.interval)
+		b.mu.Unlock()
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,175 @@
+package irc
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/irc/irctest"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/message"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"gotest.tools/v3/assert"
+)
+
+func TestClient(t *testing.T) {
+	s, err := irctest.NewServer()
+	assert.NilError(t, err)
+	defer s.Close()
+	s.Account = "cat:secret"
+
+	var mu sync.Mutex
+	var uploads []string
+	web := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			if r.URL.Path == "/paste/redirect" {
+				http.Redirect(w, r, "/elsewhere", http.StatusFound)
+				return
+			}
+			_, _ = io.WriteString(w, "pasted\n")
+		case http.MethodPost:
+			file, header, err := r.FormFile("file")
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			content, _ := io.ReadAll(file)
+			mu.Lock()
+			uploads = append(uploads, header.Filename+"="+string(content))
+			mu.Unlock()
+			_, _ = io.WriteString(w, "https://upload.example/1\n")
+		}
+	}))
+	defer web.Close()
+
+	o := &options.Options{
+		EnvCommand: []string{"/usr/bin/env", "-i", "PATH=" + os.Getenv("PATH")},
+		IRC: options.IRCOptions{
+			Channels:         []string{"#test"},
+			MaxLines:         2,
+			PasteURLPrefixes: []string{web.URL + "/paste/"},
+			SASLPassword:     "secret",
+			SASLUsername:     "cat",
+			Server:           s.Addr(),
+			UploadURL:        web.URL + "/upload",
+		},
+		NumberOfLinesToEmbedOutput:         20,
+		NumberOfLinesToEmbedUploadedOutput: 3,
+		RestTimeoutSeconds:                 10,
+		TargetCLI:                          "cat",
+		TimeoutSeconds:                     10,
+	}
+	c := New(o)
+	c.limiter = &tokenBucket{burst: sendBurst, interval: time.Millisecond}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	served := make(chan struct{})
+	go func() {
+		c.Serve(ctx)
+		close(served)
+	}()
+	defer func() {
+		c.Shutdown(ctx, time.Second)
+		<-served
+	}()
+	assert.NilError(t, s.WaitJoined(ctx, "#test"))
+
+	// waitReplies waits until the n-th PRIVMSG to the target and returns the texts so far.
+	waitReplies := func(t *testing.T, target string, n int) []string {
+		t.Helper()
+		assert.NilError(t, s.Wait(ctx, func(messages []irctest.Message) bool {
+			return len(irctest.Privmsgs(messages, target)) >= n
+		}))
+		return irctest.Privmsgs(s.Messages(), target)
+	}
+
+	t.Run("channel", func(t *testing.T) {
+		s.Privmsg("alice", "#test", "hello")
+		s.Privmsg("alice", "#test", "cat: -n <<< hello\\nworld")
+		assert.DeepEqual(t, waitReplies(t, "#test", 2), []string{"alice:      1\thello", "alice:      2\tworld"})
+	})
+
+	t.Run("uploads the lines over the limit", func(t *testing.T) {
+		s.Privmsg("alice", "#test", "cat, <<< a\\nb\\nc")
+		assert.DeepEqual(t, waitReplies(t, "#test", 5)[2:], []string{"alice: a", "alice: b", "alice: [https://upload.example/1]"})
+		mu.Lock()
+		defer mu.Unlock()
+		assert.DeepEqual(t, uploads, []string{"output.txt=a\nb\nc\n"})
+	})
+
+	t.Run("private message with paste", func(t *testing.T) {
+		s.Privmsg("bob", "cat", "-n < "+web.URL+"/paste/1")
+		assert.DeepEqual(t, waitReplies(t, "bob", 1), []string{"     1\tpasted"})
+	})
+
+	t.Run("paste not allowed", func(t *testing.T) {
+		s.Privmsg("bob", "cat", "< https://elsewhere.example/1")
+		assert.Equal(t, waitReplies(t, "bob", 2)[1], "paste URL not allowed: https://elsewhere.example/1")
+	})
+
+	t.Run("paste redirect not allowed", func(t *testing.T) {
+		s.Privmsg("carol", "cat", "< "+web.URL+"/paste/redirect")
+		reply := waitReplies(t, "carol", 1)[0]
+		assert.Assert(t, strings.HasSuffix(reply, "paste URL not allowed: "+web.URL+"/elsewhere"), reply)
+	})
+
+	t.Run("uploads the lines over the limit along with files", func(t *testing.T) {
+		conversation := &ircConversation{client: c, target: "dave"}
+		_, err := conversation.Send(ctx, &message.ExecutionResult{
+			Content: "```\na\nb\nc\n```",
+			Files:   []*discord.File{{Name: "a.png", Reader: strings.NewReader("png")}},
+		})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, waitReplies(t, "dave", 4), []string{"a", "b", "[https://upload.example/1]", "a.png: https://upload.example/1"})
+
+		// Outputs too long to embed are complete in their files.
+		_, err = conversation.Send(ctx, &message.ExecutionResult{
+			Content: "```\na\nb\nc\n```",
+			Files:   []*discord.File{{Name: "stdout.log", Reader: strings.NewReader("a\nb\nc\nd\n")}},
+		})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, waitReplies(t, "dave", 7)[4:], []string{"a", "b", "stdout.log: https://upload.example/1"})
+	})
+
+	t.Run("usage", func(t *testing.T) {
+		s.Privmsg("bob", "cat", "cat:")
+		assert.Assert(t, strings.HasPrefix(waitReplies(t, "bob", 3)[2], "usage: cat: "))
+	})
+}
+
+func TestTokenBucket(t *testing.T) {
+	ctx := context.Background()
+	b := &tokenBucket{burst: 4, interval: 50 * time.Millisecond}
+
+	start := time.Now()
+	for range 4 {
+		assert.NilError(t, b.wait(ctx))
+	}
+	assert.Assert(t, time.Since(start) < 40*time.Millisecond, "burst delayed: %v", time.Since(start))
+	for range 2 {
+		assert.NilError(t, b.wait(ctx))
+	}
+	assert.Assert(t, time.Since(start) >= 100*time.Millisecond, "not rate limited: %v", time.Since(start))
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		assert.ErrorIs(t, b.wait(ctx), context.Canceled)
+	})
+
+	t.Run("refills while idle", func(t *testing.T) {
+		time.Sleep(4 * b.interval)
+		start := time.Now()
+		for range 4 {
+			assert.NilError(t, b.wait(ctx))
+		}
+		assert.Assert(t, time.Since(start) < 40*time.Millisecond, "burst delayed: %v", time.Since(start))
+	})
+}
@@ -0,0 +1,256 @@
+// Package irctest provides an in-process IRC server, so that the IRC client can be tested end to end
+// without network access.
+//
+// The server implements only the registration, SASL PLAIN, JOIN, PING and PRIVMSG commands used by the client.
+// Messages from other users are injected with Server.Privmsg, and messages from clients are recorded.
+package irctest
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Message is a message received by the Server from a client.
+type Message struct {
+	Command string
+	Params  []string
+}
+
+// Server is an in-process IRC server on a loopback address.
+type Server struct {
+	listener net.Listener
+	// Account is the SASL account required as `username:password` before registration,
+	// or empty to accept any client.
+	Account string
+
+	mu       sync.Mutex
+	changed  chan struct{} // closed and replaced when messages or clients change
+	clients  map[net.Conn]*client
+	messages []Message
+	wg       sync.WaitGroup
+}
+
+// client is the state of a connected client.
+type client struct {
+	conn     net.Conn
+	nick     string
+	channels []string
+	// negotiating is true between CAP REQ and CAP END, which delays the registration.
+	negotiating   bool
+	authenticated bool
+	registered    bool
+}
+
+// NewServer starts a Server listening on a loopback address.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{listener: listener, changed: make(chan struct{}), clients: make(map[net.Conn]*client)}
+	s.wg.Add(1)
+	go s.accept()
+	return s, nil
+}
+
+// Addr returns the `host:port` address of the server.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close stops the server and disconnects the clients.
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.mu.Lock()
+	for conn := range s.clients {
+		_ = conn.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// Privmsg sends a PRIVMSG from the nickname to the target, either a channel or the nickname of a client.
+func (s *Server) Privmsg(from, target, text string) {
+	line := fmt.Sprintf(":%s!%s@irctest PRIVMSG %s :%s\r\n", from, from, target, text)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, c := range s.clients {
+		if strings.EqualFold(c.nick, target) || slices.Contains(c.channels, target) {
+			_, _ = io.WriteString(c.conn, line)
+		}
+	}
+}
+
+// Messages returns the messages received from the clients so far.
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.messages)
+}
+
+// Wait waits until the condition on the server becomes true or ctx is done.
+// The condition is checked with the server locked, whenever messages are received.
+func (s *Server) Wait(ctx context.Context, cond func(messages []Message) bool) error {
+	for {
+		s.mu.Lock()
+		ok, changed := cond(s.messages), s.changed
+		s.mu.Unlock()
+		if ok {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+// WaitJoined waits until a client joins the channel.
+func (s *Server) WaitJoined(ctx context.Context, channel string) error {
+	return s.Wait(ctx, func(messages []Message) bool {
+		return slices.ContainsFunc(messages, func(m Message) bool {
+			return m.Command == "JOIN" && len(m.Params) > 0 && slices.Contains(strings.Split(m.Params[0], ","), channel)
+		})
+	})
+}
+
+// Privmsgs returns the texts of the PRIVMSGs received for the target.
+func Privmsgs(messages []Message, target string) []string {
+	var texts []string
+	for _, m := range messages {
+		if m.Command == "PRIVMSG" && len(m.Params) == 2 && m.Params[0] == target {
+			texts = append(texts, m.Params[1])
+		}
+	}
+	return texts
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.clients[conn] = &client{conn: conn}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+// serve handles the lines from a client until it disconnects.
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.clients, conn)
+		s.mu.Unlock()
+		_ = conn.Close()
+	}()
+	reader := bufio.NewReader(conn)
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		if quit := s.handle(conn, parse(line)); quit {
+			return
+		}
+	}
+}
+
+// handle records and answers a message from the client, and returns true if the client quits.
+func (s *Server) handle(conn net.Conn, m Message) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, m)
+	close(s.changed)
+	s.changed = make(chan struct{})
+
+	c := s.clients[conn]
+	reply := func(format string, args ...any) {
+		_, _ = fmt.Fprintf(conn, ":irctest "+format+"\r\n", args...)
+	}
+	switch m.Command {
+	case "CAP":
+		switch param(m, 0) {
+		case "REQ":
+			c.negotiating = true
+			reply("CAP * ACK :%s", param(m, 1))
+		case "END":
+			c.negotiating = false
+		}
+	case "AUTHENTICATE":
+		if param(m, 0) == "PLAIN" {
+			_, _ = io.WriteString(conn, "AUTHENTICATE +\r\n")
+			break
+		}
+		decoded, err := base64.StdEncoding.DecodeString(param(m, 0))
+		fields := strings.Split(string(decoded), "\x00")
+		if err != nil || len(fields) != 3 || (s.Account != "" && fields[1]+":"+fields[2] != s.Account) {
+			reply("904 * :SASL authentication failed")
+			break
+		}
+		c.authenticated = true
+		reply("903 * :SASL authentication successful")
+	case "NICK":
+		if slices.ContainsFunc(slices.Collect(maps.Values(s.clients)), func(other *client) bool {
+			return other != c && strings.EqualFold(other.nick, param(m, 0))
+		}) {
+			reply("433 * %s :Nickname is already in use", param(m, 0))
+			break
+		}
+		c.nick = param(m, 0)
+	case "JOIN":
+		for _, channel := range strings.Split(param(m, 0), ",") {
+			c.channels = append(c.channels, channel)
+			reply("JOIN %s", channel)
+		}
+	case "PING":
+		reply("PONG irctest :%s", param(m, 0))
+	case "QUIT":
+		return true
+	}
+	if !c.registered && !c.negotiating && c.nick != "" && (s.Account == "" || c.authenticated) {
+		c.registered = true
+		reply("001 %s :Welcome to irctest", c.nick)
+	}
+	return false
+}
+
+// param returns the i-th parameter of the message, or an empty string if there is none.
+func param(m Message, i int) string {
+	if i < len(m.Params) {
+		return m.Params[i]
+	}
+	return ""
+}
+
+// parse parses a line from a client, which has neither tags nor a prefix.
+func parse(line string) Message {
+	line = strings.TrimRight(line, "\r\n")
+	var trailing *string
+	if before, after, ok := strings.Cut(line, " :"); ok {
+		line, trailing = before, &after
+	}
+	fields := strings.Fields(line)
+	var m Message
+	if len(fields) > 0 {
+		m.Command, m.Params = strings.ToUpper(fields[0]), fields[1:]
+	}
+	if trailing != nil {
+		m.Params = append(m.Params, *trailing)
+	}
+	return m
+}
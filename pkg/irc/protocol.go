@@ -0,0 +1,107 @@
+package irc
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTextBytes is the maximum size of the text of a PRIVMSG, leaving room for the prefix added by the server
+// within the 512 bytes limit of an IRC line.
+const maxTextBytes = 400
+
+// Message is a line of the IRC protocol.
+type Message struct {
+	// Prefix is the source of the message, e.g. `nick!user@host`, or empty.
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// ParseMessage parses a line of the IRC protocol without the trailing CRLF.
+// Message tags are ignored.
+func ParseMessage(line string) Message {
+	var m Message
+	line = strings.TrimRight(line, "\r\n")
+	if strings.HasPrefix(line, "@") {
+		_, line, _ = strings.Cut(line, " ")
+	}
+	if strings.HasPrefix(line, ":") {
+		m.Prefix, line, _ = strings.Cut(line[1:], " ")
+	}
+	for line != "" {
+		line = strings.TrimLeft(line, " ")
+		if trailing, ok := strings.CutPrefix(line, ":"); ok {
+			m.Params = append(m.Params, trailing)
+			break
+		}
+		var param string
+		param, line, _ = strings.Cut(line, " ")
+		if param == "" {
+			continue
+		}
+		if m.Command == "" {
+			m.Command = strings.ToUpper(param)
+		} else {
+			m.Params = append(m.Params, param)
+		}
+	}
+	return m
+}
+
+// Nick returns the nickname of the source of the message.
+func (m Message) Nick() string {
+	nick, _, _ := strings.Cut(m.Prefix, "!")
+	return nick
+}
+
+// Param returns the i-th parameter, or an empty string if there is none.
+func (m Message) Param(i int) string {
+	if i < len(m.Params) {
+		return m.Params[i]
+	}
+	return ""
+}
+
+// String formats the message as a line of the IRC protocol without the trailing CRLF.
+// The last parameter is always sent as a trailing parameter.
+func (m Message) String() string {
+	var b strings.Builder
+	if m.Prefix != "" {
+		b.WriteString(":" + m.Prefix + " ")
+	}
+	b.WriteString(m.Command)
+	for i, param := range m.Params {
+		if i == len(m.Params)-1 {
+			b.WriteString(" :" + param)
+		} else {
+			b.WriteString(" " + param)
+		}
+	}
+	return b.String()
+}
+
+// isChannel returns true if the target is a channel rather than a nickname.
+func isChannel(target string) bool {
+	return strings.HasPrefix(target, "#") || strings.HasPrefix(target, "&")
+}
+
+// splitText splits the text into pieces of at most maxTextBytes without splitting UTF-8 sequences.
+// Characters not allowed in IRC lines are removed.
+func splitText(text string) []string {
+	text = strings.Map(func(r rune) rune {
+		if r == '\r' || r == '\n' || r == 0 {
+			return -1
+		}
+		return r
+	}, text)
+	var pieces []string
+	for len(text) > maxTextBytes {
+		end := maxTextBytes
+		for end > 0 && !utf8.RuneStart(text[end]) {
+			end--
+		}
+		pieces = append(pieces, text[:end])
+		text = text[end:]
+	}
+	return append(pieces, text)
+}
@@ -0,0 +1,55 @@
+package irc
+
+import (
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestParseMessage(t *testing.T) {
+	m := ParseMessage("@time=2025-01-01T00:00:00Z :alice!a@host PRIVMSG #chan :bot: -n <<< a b\r\n")
+	assert.DeepEqual(t, m, Message{Prefix: "alice!a@host", Command: "PRIVMSG", Params: []string{"#chan", "bot: -n <<< a b"}})
+	assert.Equal(t, m.Nick(), "alice")
+	assert.Equal(t, m.Param(2), "")
+
+	m = ParseMessage("ping irc.example.net")
+	assert.DeepEqual(t, m, Message{Command: "PING", Params: []string{"irc.example.net"}})
+	assert.Equal(t, m.String(), "PING :irc.example.net")
+}
+
+func TestSplitText(t *testing.T) {
+	assert.DeepEqual(t, splitText("a\rb\x00c"), []string{"abc"})
+	pieces := splitText(strings.Repeat("あ", 200))
+	assert.Equal(t, len(pieces), 2)
+	assert.Equal(t, len(pieces[0]), 399)
+	assert.Equal(t, strings.Join(pieces, ""), strings.Repeat("あ", 200))
+}
+
+func TestSplitInput(t *testing.T) {
+	for _, tc := range []struct {
+		line, args, input, pasteURL string
+	}{
+		{line: "-n", args: "-n"},
+		{line: "-n <<< hello\\nworld", args: "-n", input: "hello\nworld\n"},
+		{line: "<<< a\\tb\\\\n", input: "a\tb\\n\n"},
+		{line: "-n < https://paste.example/raw/1", args: "-n", pasteURL: "https://paste.example/raw/1"},
+		{line: "-n < file", args: "-n < file"},
+	} {
+		args, input, pasteURL := splitInput(tc.line)
+		assert.Equal(t, args, tc.args, tc.line)
+		assert.Equal(t, string(input), tc.input, tc.line)
+		assert.Equal(t, pasteURL, tc.pasteURL, tc.line)
+	}
+}
+
+func TestCutAddress(t *testing.T) {
+	line, ok := cutAddress("Cat: -n", "cat")
+	assert.Assert(t, ok)
+	assert.Equal(t, line, "-n")
+	line, ok = cutAddress("cat, hi", "cat")
+	assert.Assert(t, ok)
+	assert.Equal(t, line, "hi")
+	_, ok = cutAddress("catalog: x", "cat")
+	assert.Assert(t, !ok)
+}
@@ -0,0 +1,55 @@
+// Package logging provides slog configuration and context-scoped loggers.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+)
+
+// Supported log formats.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// contextKey is the key type for values stored in a context by this package.
+type contextKey struct{}
+
+// New creates a logger writing to w with the given minimum level and format.
+func New(w io.Writer, level slog.Leveler, format string) (*slog.Logger, error) {
+	handlerOptions := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "", FormatText:
+		return slog.New(slog.NewTextHandler(w, handlerOptions)), nil
+	case FormatJSON:
+		return slog.New(slog.NewJSONHandler(w, handlerOptions)), nil
+	default:
+		return nil, fmt.Errorf("unsupported log format: %q", format)
+	}
+}
+
+// WithLogger returns a copy of ctx carrying the logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or slog.Default() if there is none.
+func FromContext(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
+
+// NewJobID generates a random identifier for correlating the logs of one job.
+func NewJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
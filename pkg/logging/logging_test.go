@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestNew(t *testing.T) {
+	t.Run("json", func(t *testing.T) {
+		var b bytes.Buffer
+		logger, err := New(&b, slog.LevelDebug, FormatJSON)
+		assert.NilError(t, err)
+		logger.Debug("hello", slog.String("job.id", "1"))
+		assert.Assert(t, strings.Contains(b.String(), `"msg":"hello","job.id":"1"`))
+	})
+
+	t.Run("level", func(t *testing.T) {
+		var b bytes.Buffer
+		logger, err := New(&b, slog.LevelWarn, FormatText)
+		assert.NilError(t, err)
+		logger.Info("hidden")
+		assert.Equal(t, b.Len(), 0)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := New(&bytes.Buffer{}, slog.LevelInfo, "xml")
+		assert.ErrorContains(t, err, `unsupported log format: "xml"`)
+	})
+}
+
+func TestFromContext(t *testing.T) {
+	assert.Equal(t, FromContext(context.Background()), slog.Default())
+	logger := slog.Default().With(slog.String("job.id", NewJobID()))
+	assert.Equal(t, FromContext(WithLogger(context.Background(), logger)), logger)
+}
+
+func TestNewJobID(t *testing.T) {
+	id := NewJobID()
+	assert.Equal(t, len(id), 16)
+	assert.Assert(t, id != NewJobID())
+}
@@ -0,0 +1,205 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"slices"
+	"strings"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/xiter"
+)
+
+// ChannelKind classifies the channel of an incoming message, which decides whether the bot must be addressed.
+type ChannelKind int
+
+const (
+	// ChannelUnsupported is a channel the bot does not answer in.
+	ChannelUnsupported ChannelKind = iota
+	// ChannelDirect is a direct conversation with the bot, where messages need not address the bot.
+	ChannelDirect
+	// ChannelShared is a channel shared with other users, where messages must address the bot.
+	ChannelShared
+)
+
+// Attachment is a file attached to an incoming message.
+type Attachment struct {
+	Name string
+	// Download returns the content of the attachment.
+	Download func(ctx context.Context) ([]byte, error)
+}
+
+// Incoming is a message received from a chat platform, independent of the platform.
+// Adapters such as IncomingFromDiscord translate the messages of a platform into it.
+type Incoming struct {
+	Channel ChannelKind
+	// Addressed is true if the message addresses the bot, e.g. by mentioning it.
+	Addressed bool
+	// CommandLines are the arguments addressed to the bot, e.g. the text after each mention, in order.
+	CommandLines []string
+	// Text is the text of the message, whose first code block is used as the standard input.
+	Text string
+	// Input is the standard input given explicitly, e.g. through a paste, which takes precedence over
+	// the attachments and the code block if not nil.
+	Input []byte
+	// Attachments are the files attached to the message; the first one with AttachmentExtensionToTreatAsInput
+	// is used as the standard input.
+	Attachments []Attachment
+	// BotName is the name used to address the bot, shown in the help.
+	BotName string
+}
+
+// Reply is a message sent by the bot in reply to an incoming message.
+type Reply interface {
+	// ID identifies the reply in logs.
+	ID() string
+	// Edit replaces the content and files of the reply.
+	Edit(ctx context.Context, r *ExecutionResult) error
+	// Delete deletes the reply.
+	Delete(ctx context.Context) error
+}
+
+// Conversation is the platform side of answering an incoming message.
+type Conversation interface {
+	// Typing shows that the bot is working on the message.
+	Typing(ctx context.Context) error
+	// Send sends a new reply.
+	Send(ctx context.Context, r *ExecutionResult) (Reply, error)
+}
+
+// ExecuteIncoming executes the commands addressed to the bot in the incoming message.
+// Each command line runs the target CLI with the same standard input, and in direct conversations a message
+// without command lines runs the default arguments, or shows the help if there is no input either.
+// It returns a sequence of Futures, each representing the asynchronous execution result of a command.
+// A command line that cannot be parsed, e.g. with an invalid directive, results in the error as the reply.
+// The opts are passed to future.New starting each command, e.g. to bound them with an Executor.
+func ExecuteIncoming(ctx context.Context, o *options.Options, c Conversation, m Incoming, opts ...future.Option) iter.Seq[future.Future[*ExecutionResult]] {
+	// Ensure the context has a timeout for rest operations.
+	restCtx, cancel := o.ContextWithRestTimeout(ctx)
+	defer cancel()
+
+	emptySeq := xiter.SeqOf[future.Future[*ExecutionResult]]()
+	defaultCmds := make([]string, 0)
+	switch m.Channel {
+	case ChannelShared:
+		if !m.Addressed {
+			return emptySeq
+		}
+	case ChannelDirect:
+		defaultCmds = append(defaultCmds, "")
+	default:
+		return emptySeq
+	}
+	// detect input from attachments or code blocks
+	input := m.Input
+	if input == nil {
+		var err error
+		input, err = inputFromAttachments(restCtx, m.Attachments, o.AttachmentExtensionToTreatAsInput)
+		if err != nil {
+			return xiter.SeqOf(future.NewError[*ExecutionResult](err))
+		} else if input == nil {
+			input = inputFromCodeblock(m.Text)
+		}
+	}
+	cmds := m.CommandLines
+	if len(cmds) == 0 {
+		cmds = defaultCmds
+	}
+	// If multiple commands are provided, we will output the command being executed.
+	outputCmd := len(cmds) > 1
+
+	// If commands are provided, we will show that the bot is typing.
+	if len(cmds) > 0 {
+		_ = c.Typing(restCtx)
+	}
+	// Prepare the commands for execution, deduplicating them.
+	seqCmds := xiter.Dedupe(slices.Values(cmds))
+	executeCmdFunc := func(cmd string) future.Future[*ExecutionResult] {
+		var reader io.Reader
+		if input != nil {
+			reader = bytes.NewReader(input)
+		} else if strings.TrimSpace(cmd) == "" {
+			// If the command is empty and no input is provided, return a help message.
+			return future.NewDeferred(func(_ context.Context) (*ExecutionResult, error) {
+				return helpResult(m.BotName)
+			})
+		}
+		return future.New(ctx, func(ctx context.Context) (*ExecutionResult, error) {
+			result, err := executeTarget(ctx, o, cmd, reader, nil, outputCmd)
+			if errors.Is(err, ErrInvalidArgs) {
+				// Tell the author what is wrong with the command line instead of leaving it unanswered.
+				return &ExecutionResult{Content: err.Error()}, nil
+			}
+			return result, err
+		}, opts...)
+	}
+	return xiter.Map(seqCmds, executeCmdFunc)
+}
+
+// SyncReplies makes the replies to a message match the results of executing it again:
+// the n-th reply is edited to the n-th result, results without a reply are sent as new replies,
+// and replies without a result are deleted along with the stale replies.
+// The results are yielded with their index as they complete, e.g. by future.AwaitUnordered,
+// so that a reply is edited as soon as its result is ready, while new replies are still sent in order.
+// Replies of results that failed are kept as they are. It stops at the first error, or when ctx is done.
+func SyncReplies(ctx context.Context, c Conversation, results iter.Seq2[int, future.Result[*ExecutionResult]], replies, stale iter.Seq[Reply]) error {
+	existing := slices.Collect(replies)
+	// Results beyond the existing replies wait until the results before them have been sent.
+	pending := make(map[int]*ExecutionResult)
+	next, count := len(existing), 0
+	for i, r := range results {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		count++
+		if i < len(existing) {
+			if r.Value == nil {
+				continue
+			}
+			if err := existing[i].Edit(ctx, r.Value); err != nil {
+				return fmt.Errorf("failed to update reply %s: %w", existing[i].ID(), err)
+			}
+			continue
+		}
+		pending[i] = r.Value
+		for value, ok := pending[next]; ok; value, ok = pending[next] {
+			delete(pending, next)
+			next++
+			if value == nil {
+				continue
+			}
+			if _, err := c.Send(ctx, value); err != nil {
+				return fmt.Errorf("failed to send reply: %w", err)
+			}
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for _, reply := range existing[min(count, len(existing)):] {
+		if err := reply.Delete(ctx); err != nil {
+			return fmt.Errorf("failed to delete reply %s: %w", reply.ID(), err)
+		}
+	}
+	for reply := range stale {
+		if err := reply.Delete(ctx); err != nil {
+			return fmt.Errorf("failed to delete reply %s: %w", reply.ID(), err)
+		}
+	}
+	return nil
+}
+
+// inputFromAttachments returns the content of the first attachment whose name ends with the given extension,
+// or nil if there is none.
+func inputFromAttachments(ctx context.Context, attachments []Attachment, extension string) ([]byte, error) {
+	i := slices.IndexFunc(attachments, func(a Attachment) bool { return strings.HasSuffix(a.Name, extension) })
+	if i < 0 {
+		return nil, nil // No matching attachment found
+	}
+	return attachments[i].Download(ctx)
+}
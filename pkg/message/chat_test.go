@@ -0,0 +1,179 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"slices"
+	"testing"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"gotest.tools/v3/assert"
+)
+
+// fakeConversation records the operations on a Conversation and its replies.
+type fakeConversation struct {
+	ops    []string
+	typing int
+	sent   int
+	err    error
+}
+
+func (c *fakeConversation) Typing(context.Context) error {
+	c.typing++
+	return nil
+}
+
+func (c *fakeConversation) Send(_ context.Context, r *ExecutionResult) (Reply, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+	c.sent++
+	c.ops = append(c.ops, "send "+r.Content)
+	return fakeReply{c: c, id: fmt.Sprintf("new%d", c.sent)}, nil
+}
+
+type fakeReply struct {
+	c  *fakeConversation
+	id string
+}
+
+func (r fakeReply) ID() string {
+	return r.id
+}
+
+func (r fakeReply) Edit(_ context.Context, result *ExecutionResult) error {
+	r.c.ops = append(r.c.ops, "edit "+r.id+" "+result.Content)
+	return nil
+}
+
+func (r fakeReply) Delete(context.Context) error {
+	r.c.ops = append(r.c.ops, "delete "+r.id)
+	return nil
+}
+
+func TestExecuteIncoming(t *testing.T) {
+	ctx := context.Background()
+	o := testOptions()
+
+	t.Run("not addressed in shared channel", func(t *testing.T) {
+		c := &fakeConversation{}
+		m := Incoming{Channel: ChannelShared, Text: "```\nhello\n```"}
+		assert.Equal(t, len(slices.Collect(ExecuteIncoming(ctx, o, c, m))), 0)
+		assert.Equal(t, c.typing, 0)
+	})
+
+	t.Run("unsupported channel", func(t *testing.T) {
+		m := Incoming{Addressed: true, CommandLines: []string{""}}
+		assert.Equal(t, len(slices.Collect(ExecuteIncoming(ctx, o, &fakeConversation{}, m))), 0)
+	})
+
+	t.Run("input takes precedence over attachments and code block", func(t *testing.T) {
+		c := &fakeConversation{}
+		m := Incoming{
+			Channel:      ChannelShared,
+			Addressed:    true,
+			CommandLines: []string{""},
+			Text:         "```\ncode block\n```",
+			Input:        []byte("input\n"),
+			Attachments: []Attachment{{Name: "a.txt", Download: func(context.Context) ([]byte, error) {
+				return []byte("attachment\n"), nil
+			}}},
+		}
+		results := slices.Collect(future.Await(ctx, ExecuteIncoming(ctx, o, c, m)))
+		assert.Equal(t, len(results), 1)
+		assert.Equal(t, results[0].Value.Content, "```\ninput\n```")
+		assert.Equal(t, c.typing, 1)
+
+		m.Input = nil
+		results = slices.Collect(future.Await(ctx, ExecuteIncoming(ctx, o, c, m)))
+		assert.Equal(t, results[0].Value.Content, "```\nattachment\n```")
+
+		m.Attachments = nil
+		results = slices.Collect(future.Await(ctx, ExecuteIncoming(ctx, o, c, m)))
+		assert.Equal(t, results[0].Value.Content, "```\ncode block\n```")
+	})
+
+	t.Run("invalid directive is answered", func(t *testing.T) {
+		o := testOptions()
+		o.MaxTimeoutSeconds = 60
+		m := Incoming{Channel: ChannelShared, Addressed: true, CommandLines: []string{"timeout=abc"}}
+		results := slices.Collect(future.Await(ctx, ExecuteIncoming(ctx, o, &fakeConversation{}, m)))
+		assert.Equal(t, len(results), 1)
+		assert.NilError(t, results[0].Err)
+		assert.Equal(t, results[0].Value.Content, "invalid arguments: invalid timeout directive \"timeout=abc\": must be a positive number of seconds")
+	})
+
+	t.Run("help in direct conversation", func(t *testing.T) {
+		m := Incoming{Channel: ChannelDirect, Text: "hello", BotName: "bot"}
+		results := slices.Collect(future.Await(ctx, ExecuteIncoming(ctx, o, &fakeConversation{}, m)))
+		assert.Equal(t, len(results), 1)
+		assert.Equal(t, results[0].Value.Content, "```\nUsage:\n@bot\n`\u200b`\u200b`\n[contents for standard input]\n`\u200b`\u200b`\n```")
+	})
+}
+
+func TestSyncReplies(t *testing.T) {
+	ctx := context.Background()
+	result := func(content string) future.Result[*ExecutionResult] {
+		return future.Result[*ExecutionResult]{Value: &ExecutionResult{Content: content}}
+	}
+	failed := future.Result[*ExecutionResult]{Err: errors.New("failed")}
+	// indexed yields the results at the indices in the given order.
+	indexed := func(order []int, results ...future.Result[*ExecutionResult]) iter.Seq2[int, future.Result[*ExecutionResult]] {
+		return func(yield func(int, future.Result[*ExecutionResult]) bool) {
+			for _, i := range order {
+				if !yield(i, results[i]) {
+					return
+				}
+			}
+		}
+	}
+
+	t.Run("edits, sends and deletes", func(t *testing.T) {
+		c := &fakeConversation{}
+		replies := []Reply{fakeReply{c, "r1"}, fakeReply{c, "r2"}}
+		err := SyncReplies(ctx, c, slices.All([]future.Result[*ExecutionResult]{result("a"), failed, result("c")}),
+			slices.Values(replies), slices.Values([]Reply{fakeReply{c, "stale"}}))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, c.ops, []string{"edit r1 a", "send c", "delete stale"})
+
+		c.ops = nil
+		err = SyncReplies(ctx, c, slices.All([]future.Result[*ExecutionResult]{}), slices.Values(replies), slices.Values([]Reply{}))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, c.ops, []string{"delete r1", "delete r2"})
+	})
+
+	t.Run("edits as results arrive and sends in order", func(t *testing.T) {
+		c := &fakeConversation{}
+		replies := []Reply{fakeReply{c, "r1"}, fakeReply{c, "r2"}}
+		err := SyncReplies(ctx, c, indexed([]int{1, 3, 0, 2}, result("a"), result("b"), result("c"), result("d")),
+			slices.Values(replies), slices.Values([]Reply{}))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, c.ops, []string{"edit r2 b", "edit r1 a", "send c", "send d"})
+
+		c.ops = nil
+		err = SyncReplies(ctx, c, indexed([]int{2, 1, 0}, result("a"), failed, result("c")),
+			slices.Values([]Reply{}), slices.Values([]Reply{}))
+		assert.NilError(t, err)
+		assert.DeepEqual(t, c.ops, []string{"send a", "send c"})
+	})
+
+	t.Run("stops at the first error", func(t *testing.T) {
+		c := &fakeConversation{err: errors.New("forbidden")}
+		err := SyncReplies(ctx, c, slices.All([]future.Result[*ExecutionResult]{result("a")}),
+			slices.Values([]Reply{}), slices.Values([]Reply{fakeReply{c, "stale"}}))
+		assert.ErrorContains(t, err, "failed to send reply: forbidden")
+		assert.Equal(t, len(c.ops), 0)
+	})
+
+	t.Run("stops when ctx is done", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		c := &fakeConversation{}
+		err := SyncReplies(ctx, c, slices.All([]future.Result[*ExecutionResult]{result("a")}),
+			slices.Values([]Reply{fakeReply{c, "r1"}, fakeReply{c, "r2"}}), slices.Values([]Reply{}))
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Equal(t, len(c.ops), 0)
+	})
+}
@@ -0,0 +1,45 @@
+// Package message provides utilities for parsing, executing, and replying to chat messages, such as Discord messages.
+package message
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+)
+
+// directives holds per-run adjustments given as leading `name=value` tokens on a mention line,
+// e.g. `@bot timeout=60 RUST_BACKTRACE=1 args...`.
+type directives struct {
+	// TimeoutSeconds is the requested timeout, or 0 if not requested.
+	TimeoutSeconds int
+	// Env holds `NAME=VALUE` assignments passed to the target CLI.
+	Env []string
+}
+
+// parseDirectives consumes leading directive tokens from args and returns them with the remaining args.
+// `timeout=<seconds>` is a directive only if MaxTimeoutSeconds is set, and `NAME=value` only if NAME is
+// listed in DirectiveEnvNames. Parsing stops at the first token that is not a directive.
+func parseDirectives(o *options.Options, args []string) (directives, []string, error) {
+	var d directives
+	for i, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		switch {
+		case !ok:
+			return d, args[i:], nil
+		case name == "timeout" && o.MaxTimeoutSeconds > 0:
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return d, nil, fmt.Errorf("invalid timeout directive \"%s\": must be a positive number of seconds", arg)
+			}
+			d.TimeoutSeconds = seconds
+		case slices.Contains(o.DirectiveEnvNames, name):
+			d.Env = append(d.Env, arg)
+		default:
+			return d, args[i:], nil
+		}
+	}
+	return d, nil, nil
+}
@@ -0,0 +1,45 @@
+package message
+
+import (
+	"testing"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"gotest.tools/v3/assert"
+)
+
+func TestParseDirectives(t *testing.T) {
+	o := &options.Options{MaxTimeoutSeconds: 120, DirectiveEnvNames: []string{"RUST_BACKTRACE"}}
+
+	t.Run("leading directives", func(t *testing.T) {
+		d, args, err := parseDirectives(o, []string{"timeout=60", "RUST_BACKTRACE=1", "-O", "FOO=bar"})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, d, directives{TimeoutSeconds: 60, Env: []string{"RUST_BACKTRACE=1"}})
+		assert.DeepEqual(t, args, []string{"-O", "FOO=bar"})
+	})
+
+	t.Run("not allowlisted", func(t *testing.T) {
+		d, args, err := parseDirectives(o, []string{"FOO=bar", "RUST_BACKTRACE=1"})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, d, directives{})
+		assert.DeepEqual(t, args, []string{"FOO=bar", "RUST_BACKTRACE=1"})
+	})
+
+	t.Run("timeout disabled", func(t *testing.T) {
+		d, args, err := parseDirectives(&options.Options{}, []string{"timeout=60"})
+		assert.NilError(t, err)
+		assert.DeepEqual(t, d, directives{})
+		assert.DeepEqual(t, args, []string{"timeout=60"})
+	})
+
+	t.Run("invalid timeout", func(t *testing.T) {
+		_, _, err := parseDirectives(o, []string{"timeout=forever"})
+		assert.ErrorContains(t, err, "invalid timeout directive")
+	})
+
+	t.Run("only directives", func(t *testing.T) {
+		d, args, err := parseDirectives(o, []string{"timeout=10"})
+		assert.NilError(t, err)
+		assert.Equal(t, d.TimeoutSeconds, 10)
+		assert.Equal(t, len(args), 0)
+	})
+}
@@ -0,0 +1,231 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"log/slog"
+	"net/http"
+
+	"github.com/disgoorg/disgo/bot"
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/logging"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/replystore"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/xiter"
+)
+
+// Discord is the part of the Discord API used to execute commands and manage replies.
+// It is implemented for a bot.Client by NewDiscord, and by messagetest.Discord in tests.
+type Discord interface {
+	// SelfUser returns the bot user.
+	SelfUser() discord.User
+	// GetChannel returns the channel.
+	GetChannel(ctx context.Context, channelID snowflake.ID) (discord.Channel, error)
+	// GetMessage returns the message in the channel.
+	GetMessage(ctx context.Context, channelID, messageID snowflake.ID) (*discord.Message, error)
+	// GetMessages returns up to limit messages in the channel after the given message, newest first.
+	GetMessages(ctx context.Context, channelID, after snowflake.ID, limit int) ([]discord.Message, error)
+	// CreateMessage posts a message in the channel.
+	CreateMessage(ctx context.Context, channelID snowflake.ID, m discord.MessageCreate) (*discord.Message, error)
+	// UpdateMessage edits a message in the channel.
+	UpdateMessage(ctx context.Context, channelID, messageID snowflake.ID, m discord.MessageUpdate) (*discord.Message, error)
+	// DeleteMessage deletes a message in the channel.
+	DeleteMessage(ctx context.Context, channelID, messageID snowflake.ID) error
+	// SendTyping shows the typing indicator in the channel.
+	SendTyping(ctx context.Context, channelID snowflake.ID) error
+	// DownloadAttachment returns the content of the attachment.
+	DownloadAttachment(ctx context.Context, a discord.Attachment) ([]byte, error)
+}
+
+// NewDiscord returns the Discord API of the client.
+func NewDiscord(c bot.Client) Discord {
+	return clientDiscord{c}
+}
+
+// clientDiscord implements Discord with a bot.Client.
+type clientDiscord struct {
+	c bot.Client
+}
+
+func (d clientDiscord) SelfUser() discord.User {
+	if user, ok := d.c.Caches().SelfUser(); ok {
+		return user.User
+	}
+	return discord.User{ID: d.c.ID()}
+}
+
+func (d clientDiscord) GetChannel(ctx context.Context, channelID snowflake.ID) (discord.Channel, error) {
+	return d.c.Rest().GetChannel(channelID, rest.WithCtx(ctx))
+}
+
+func (d clientDiscord) GetMessage(ctx context.Context, channelID, messageID snowflake.ID) (*discord.Message, error) {
+	return d.c.Rest().GetMessage(channelID, messageID, rest.WithCtx(ctx))
+}
+
+func (d clientDiscord) GetMessages(ctx context.Context, channelID, after snowflake.ID, limit int) ([]discord.Message, error) {
+	return d.c.Rest().GetMessages(channelID, 0, 0, after, limit, rest.WithCtx(ctx))
+}
+
+func (d clientDiscord) CreateMessage(ctx context.Context, channelID snowflake.ID, m discord.MessageCreate) (*discord.Message, error) {
+	return d.c.Rest().CreateMessage(channelID, m, rest.WithCtx(ctx))
+}
+
+func (d clientDiscord) UpdateMessage(ctx context.Context, channelID, messageID snowflake.ID, m discord.MessageUpdate) (*discord.Message, error) {
+	return d.c.Rest().UpdateMessage(channelID, messageID, m, rest.WithCtx(ctx))
+}
+
+func (d clientDiscord) DeleteMessage(ctx context.Context, channelID, messageID snowflake.ID) error {
+	return d.c.Rest().DeleteMessage(channelID, messageID, rest.WithCtx(ctx))
+}
+
+func (d clientDiscord) SendTyping(ctx context.Context, channelID snowflake.ID) error {
+	return d.c.Rest().SendTyping(channelID, rest.WithCtx(ctx))
+}
+
+func (d clientDiscord) DownloadAttachment(ctx context.Context, a discord.Attachment) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", a.URL, http.NoBody)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request for attachment %s: %w", a.Filename, err)
+	}
+	resp, err := d.c.Rest().HTTPClient().Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download attachment %s: %w", a.Filename, err)
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			logging.FromContext(ctx).Error("Failed to close response body", slog.String("attachment", a.Filename), slog.Any("error", err))
+		}
+	}()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download attachment %s: %s", a.Filename, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read attachment %s: %w", a.Filename, err)
+	}
+	return body, nil
+}
+
+// IncomingFromDiscord translates a Discord message into an Incoming message.
+// Ignored messages and channels other than text channels, threads and DMs are ChannelUnsupported.
+func IncomingFromDiscord(ctx context.Context, o *options.Options, d Discord, e *events.GenericMessage) (Incoming, error) {
+	if ShouldIgnore(e) {
+		return Incoming{}, nil
+	}
+	// Ensure the context has a timeout for rest operations.
+	restCtx, cancel := o.ContextWithRestTimeout(ctx)
+	defer cancel()
+	channelType, err := ChannelType(restCtx, d, e)
+	if err != nil {
+		return Incoming{}, err
+	}
+	self := d.SelfUser()
+	m := Incoming{
+		Addressed:    mentioning(e, self.ID),
+		CommandLines: commandlinesFromMentions(e.Message.Content, self.ID),
+		Text:         e.Message.Content,
+		BotName:      self.Username,
+	}
+	switch channelType {
+	case discord.ChannelTypeGuildText, discord.ChannelTypeGuildPublicThread, discord.ChannelTypeGuildPrivateThread:
+		m.Channel = ChannelShared
+	case discord.ChannelTypeDM:
+		m.Channel = ChannelDirect
+	}
+	for _, a := range e.Message.Attachments {
+		m.Attachments = append(m.Attachments, Attachment{
+			Name:     a.Filename,
+			Download: func(ctx context.Context) ([]byte, error) { return d.DownloadAttachment(ctx, a) },
+		})
+	}
+	return m, nil
+}
+
+// NewDiscordConversation returns the Conversation answering the Discord message,
+// which records the replies in the store.
+func NewDiscordConversation(o *options.Options, d Discord, s replystore.Store, e *events.GenericMessage) *DiscordConversation {
+	return &DiscordConversation{o: o, d: d, s: s, e: e}
+}
+
+// DiscordConversation is the Conversation answering a Discord message.
+// The store is nil if the conversation is only used to execute commands.
+type DiscordConversation struct {
+	o *options.Options
+	d Discord
+	s replystore.Store
+	e *events.GenericMessage
+}
+
+// Replies returns the Reply handles of the replies to the message.
+func (c *DiscordConversation) Replies(replies iter.Seq[discord.Message]) iter.Seq[Reply] {
+	return xiter.Map(replies, func(m discord.Message) Reply { return discordReply{c: c, m: m} })
+}
+
+func (c *DiscordConversation) Typing(ctx context.Context) error {
+	return c.d.SendTyping(ctx, c.e.ChannelID)
+}
+
+func (c *DiscordConversation) Send(ctx context.Context, r *ExecutionResult) (Reply, error) {
+	m, err := SendReply(c.o, c.d, c.s, c.e, r).Await(ctx)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	return discordReply{c: c, m: *m}, nil
+}
+
+// discordReply implements Reply for a reply to a Discord message.
+type discordReply struct {
+	c *DiscordConversation
+	m discord.Message
+}
+
+func (r discordReply) ID() string {
+	return r.m.ID.String()
+}
+
+// Edit edits the reply, or sends a new reply if it has been deleted, e.g. by a moderator,
+// since the reply store may still record it.
+func (r discordReply) Edit(ctx context.Context, result *ExecutionResult) error {
+	_, err := UpdateMessage(r.c.o, r.c.d, r.c.e, r.m, result).Await(ctx)
+	if !IsNotFound(err) {
+		return err
+	}
+	r.c.forget(ctx, r.m.ID)
+	_, err = r.c.Send(ctx, result)
+	return err
+}
+
+// Delete deletes the reply, which succeeds if it has already been deleted.
+func (r discordReply) Delete(ctx context.Context) error {
+	_, err := DeleteMessage(r.c.o, r.c.d, r.c.s, r.c.e, r.m.ID).Await(ctx)
+	if IsNotFound(err) {
+		r.c.forget(ctx, r.m.ID)
+		return nil
+	}
+	return err
+}
+
+// forget removes a reply that no longer exists from the store, so that later events do not try it again.
+func (c *DiscordConversation) forget(ctx context.Context, id snowflake.ID) {
+	logger := logging.FromContext(ctx)
+	logger.Info("Reply not found", slog.Any("reply.id", id))
+	if c.s == nil {
+		return
+	}
+	if err := c.s.Remove(c.e.MessageID, id); err != nil {
+		logger.Warn("Failed to forget reply", slog.Any("reply.id", id), slog.Any("err", err))
+	}
+}
+
+// IsNotFound returns true if err is a REST error with status 404 Not Found,
+// e.g. for a message or channel deleted meanwhile.
+func IsNotFound(err error) bool {
+	var restErr *rest.Error
+	return errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusNotFound
+}
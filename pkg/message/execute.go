@@ -0,0 +1,49 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/shellwords"
+)
+
+// ExecuteRequest is a request to execute the target CLI outside of Discord, e.g. through the HTTP API.
+type ExecuteRequest struct {
+	// Args are the arguments of a mention line, which may start with directives such as `timeout=60`.
+	Args []string
+	// Stdin is the standard input, or nil to run without input like a message without a code block.
+	Stdin []byte
+	// Files are written to the working directory before running.
+	Files []InputFile
+}
+
+// Execute executes the target CLI for the request like for a mention line,
+// with the same timeout, directives and output limits as Discord executions.
+// It returns an error wrapping ErrInvalidArgs if the arguments or file names are invalid.
+// The opts are passed to future.New, e.g. to share the Executor of Discord executions.
+func Execute(ctx context.Context, o *options.Options, r ExecuteRequest, opts ...future.Option) (*ExecutionResult, error) {
+	names := make(map[string]bool, len(r.Files))
+	for _, file := range r.Files {
+		if file.Name == "" || file.Name != filepath.Base(file.Name) || file.Name == "." || file.Name == ".." {
+			return nil, fmt.Errorf("%w: invalid file name %q", ErrInvalidArgs, file.Name)
+		}
+		if names[file.Name] {
+			return nil, fmt.Errorf("%w: duplicate file name %q", ErrInvalidArgs, file.Name)
+		}
+		names[file.Name] = true
+	}
+	var input io.Reader
+	if r.Stdin != nil {
+		input = bytes.NewReader(r.Stdin)
+	}
+	f := future.New(ctx, func(ctx context.Context) (*ExecutionResult, error) {
+		return executeTarget(ctx, o, shellwords.Join(r.Args), input, r.Files, false)
+	}, opts...)
+	// The execution observes ctx itself; waiting without it ensures the process has ended on return.
+	return f.Await(context.WithoutCancel(ctx))
+}
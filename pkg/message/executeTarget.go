@@ -1,9 +1,10 @@
-// Package message provides utilities for parsing, executing, and replying to Discord messages.
+// Package message provides utilities for parsing, executing, and replying to chat messages, such as Discord messages.
 package message
 
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
@@ -12,9 +13,12 @@ import (
 	"path/filepath"
 	"slices"
 	"syscall"
+	"time"
 	"unicode/utf8"
 
 	"github.com/disgoorg/disgo/discord"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/logging"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/metrics"
 	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
 	"github.com/norio-nomura/cli_discord_bot2/pkg/shellwords"
 )
@@ -25,25 +29,61 @@ type ExecutionResult struct {
 	Files   []*discord.File
 }
 
+// outputFileExt is the extension of the files attaching stdout and stderr longer than the embed limits.
+const outputFileExt = ".log"
+
+// HasOutputFiles returns true if stdout or stderr is too long to embed and attached as a file,
+// whose content is then complete unlike the embedded preview.
+func (r *ExecutionResult) HasOutputFiles() bool {
+	return slices.ContainsFunc(r.Files, func(f *discord.File) bool {
+		return f.Name == "stdout"+outputFileExt || f.Name == "stderr"+outputFileExt
+	})
+}
+
+// waitDelay is how long a cancelled command may take to finish before it is force killed.
+var waitDelay = 5 * time.Second
+
+// InputFile is a file written to the working directory of the target CLI before it runs.
+type InputFile struct {
+	Name    string
+	Content []byte
+}
+
+// ErrInvalidArgs is returned when the command line or its directives cannot be parsed.
+var ErrInvalidArgs = errors.New("invalid arguments")
+
 // executeTarget executes a command with the given options and input, then returns the execution result.
-// It runs the command in a temporary directory, captures output, and returns both content and files.
+// It runs the command in a temporary directory containing the input files, captures output, and returns
+// both content and files. Input files left unchanged by the command are not returned.
+// A command that times out still returns its outputs, but one cancelled through ctx fails with context.Canceled.
 func executeTarget(
 	ctx context.Context,
 	o *options.Options,
 	commandline string,
 	input io.Reader,
+	inputFiles []InputFile,
 	outputCommandline bool,
 ) (*ExecutionResult, error) {
-	// Create a temporary directory for execution
-	cwd, err := os.MkdirTemp("", "execute_target")
+	logger := logging.FromContext(ctx)
+	// Create a temporary workspace for execution
+	workspace, err := os.MkdirTemp("", "execute_target")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temp directory: %w", err)
 	}
 	defer func() {
-		if err := os.RemoveAll(cwd); err != nil {
-			slog.Error("executeTarget", slog.String("error", fmt.Sprintf("failed to remove temp directory %s: %v", cwd, err)))
+		if err := os.RemoveAll(workspace); err != nil {
+			logger.Error("executeTarget", slog.String("error", fmt.Sprintf("failed to remove temp directory %s: %v", workspace, err)))
 		}
 	}()
+	cwd, env, err := prepareWorkspace(o, workspace)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range inputFiles {
+		if err := os.WriteFile(filepath.Join(cwd, file.Name), file.Content, 0o600); err != nil {
+			return nil, fmt.Errorf("failed to write input file: %w", err)
+		}
+	}
 
 	contentMax := 2000
 	content := ""
@@ -53,7 +93,12 @@ func executeTarget(
 	// Parse the commandline into executable and arguments using shellwords
 	args, err := shellwords.Split(commandline)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse commandline \"%s\" with error: %w", commandline, err)
+		return nil, fmt.Errorf("%w: failed to parse commandline \"%s\" with error: %w", ErrInvalidArgs, commandline, err)
+	}
+	// Consume leading directives adjusting the timeout and environment for this run only
+	d, args, err := parseDirectives(o, args)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidArgs, err)
 	}
 	if len(args) == 0 {
 		args = o.TargetDefaultArgs
@@ -62,19 +107,21 @@ func executeTarget(
 	if input != nil {
 		cli = append(cli, o.TargetArgsToUseStdin...)
 	}
-	args = slices.Concat(o.EnvCommand, cli)
+	args = slices.Concat(o.EnvCommand, env, d.Env, cli)
 
 	if outputCommandline {
 		content += fmt.Sprintf("`%s`\n", shellwords.Join(cli))
 	}
 
 	// Create a new context with a timeout for the command execution.
-	ctx, cancel := o.ContextWithTimeout(ctx)
+	ctx, cancel := o.ContextWithTimeoutSeconds(ctx, d.TimeoutSeconds)
 	defer cancel()
 
 	// Prepare the command
 	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
 	cmd.Dir = cwd
+	// Never pass configuration variables such as `DISCORD_TOKEN` to the target CLI.
+	cmd.Env = options.Environ()
 	cmd.Stdin = input
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
@@ -82,27 +129,39 @@ func executeTarget(
 	// Ensure the command runs in a new process group to allow for proper cancellation.
 	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
 	cmd.Cancel = func() error {
+		// Once cancelled, wait up to waitDelay for the command to finish before force killing it.
+		// It is set here rather than before starting so that finished runs still wait for the outputs of
+		// background processes; Wait reads it only after Cancel returns.
+		cmd.WaitDelay = waitDelay
 		// If the command is running, send a SIGINT to the process group.
 		return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
 	}
-	// Waits for the command to finish before force killing it.
-	// cmd.WaitDelay = 5 * time.Second
 
 	// Run the command
-	if err = cmd.Run(); err != nil {
+	start := time.Now()
+	err = cmd.Run()
+	if cmd.Process != nil {
+		// Kill any process left in the process group, e.g. background processes or ones ignoring SIGINT.
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	observeExecution(o, cmd, errors.Is(ctx.Err(), context.DeadlineExceeded), time.Since(start), &stdout, &stderr)
+	if err != nil && errors.Is(ctx.Err(), context.Canceled) {
+		// Cancelled by the caller, e.g. on shutdown, rather than timed out; the outputs are incomplete.
+		logger.Warn("executeTarget", slog.String("args", shellwords.Join(args)), slog.String("error", err.Error()))
+		return nil, fmt.Errorf("execution cancelled: %w", context.Cause(ctx))
+	}
+	if err != nil {
 		var errString string
 		switch ctx.Err() {
-		case context.Canceled:
-			errString = err.Error()
 		case context.DeadlineExceeded:
 			errString = context.Cause(ctx).Error()
 		default:
 			errString = err.Error()
 		}
-		slog.Error("executeTarget", slog.String("args", shellwords.Join(args)), slog.String("error", errString))
+		logger.Error("executeTarget", slog.String("args", shellwords.Join(args)), slog.String("error", errString))
 		content += fmt.Sprintf("%s with ", errString)
 	} else {
-		slog.Info("executeTarget", slog.String("args", shellwords.Join(args)))
+		logger.Info("executeTarget", slog.String("args", shellwords.Join(args)))
 	}
 
 	// Process outputs
@@ -136,7 +195,7 @@ func executeTarget(
 			}
 			if reader != nil {
 				files = append(files, &discord.File{
-					Name:   out.Name + ".log",
+					Name:   out.Name + outputFileExt,
 					Reader: reader,
 				})
 			}
@@ -155,6 +214,9 @@ func executeTarget(
 			if err != nil {
 				return nil, fmt.Errorf("failed to read file %s: %w", path, err)
 			}
+			if slices.ContainsFunc(inputFiles, func(f InputFile) bool { return f.Name == entry.Name() && bytes.Equal(f.Content, data) }) {
+				continue
+			}
 			files = append(files, &discord.File{
 				Name:   entry.Name(),
 				Reader: bytes.NewReader(data),
@@ -168,6 +230,41 @@ func executeTarget(
 	}, nil
 }
 
+// observeExecution records the metrics of a finished target CLI execution.
+func observeExecution(o *options.Options, cmd *exec.Cmd, timedOut bool, duration time.Duration, stdout, stderr *bytes.Buffer) {
+	exitCode := -1
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	metrics.ObserveExecution(o.TargetCLI, exitCode, timedOut, duration)
+	metrics.ObserveOutput(o.TargetCLI, "stdout", stdout.Len())
+	metrics.ObserveOutput(o.TargetCLI, "stderr", stderr.Len())
+}
+
+// prepareWorkspace creates the working directory and the per-run directories inside the workspace.
+// It returns the working directory and the `KEY=VALUE` assignments to pass to EnvCommand,
+// where each variable in EnvWorkspaceDirs points to its own directory in the workspace named after it.
+func prepareWorkspace(o *options.Options, workspace string) (cwd string, env []string, err error) {
+	cwd = filepath.Join(workspace, "cwd")
+	if err := os.Mkdir(cwd, 0o700); err != nil {
+		return "", nil, fmt.Errorf("failed to create working directory: %w", err)
+	}
+	// The directories of the variables are kept apart from the working directory, whatever their names are.
+	dirs := filepath.Join(workspace, "env")
+	if err := os.Mkdir(dirs, 0o700); err != nil {
+		return "", nil, fmt.Errorf("failed to create directory for variables: %w", err)
+	}
+	env = o.TargetEnv()
+	for _, name := range o.EnvWorkspaceDirs {
+		dir := filepath.Join(dirs, name)
+		if err := os.Mkdir(dir, 0o700); err != nil {
+			return "", nil, fmt.Errorf("failed to create directory for %s: %w", name, err)
+		}
+		env = append(env, name+"="+dir)
+	}
+	return cwd, env, nil
+}
+
 // bytesToEmbedAndReader splits the byte slice into a string for embedding and a reader for uploading as a file.
 // It limits the number of lines and runes in the embed, and provides a preview if the output is too large.
 func bytesToEmbedAndReader(b []byte, maxLines, maxRunes, previewLines int) (string, *bytes.Reader) {
@@ -0,0 +1,82 @@
+package message
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestExecuteTarget(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("waits for background processes holding the outputs", func(t *testing.T) {
+		defer func(d time.Duration) { waitDelay = d }(waitDelay)
+		waitDelay = 10 * time.Millisecond
+		o := testOptions()
+		o.TargetCLI = "sh"
+		r, err := executeTarget(ctx, o, `-c "(sleep 0.5; echo later) & echo now"`, nil, nil, false)
+		assert.NilError(t, err)
+		assert.Equal(t, r.Content, "```\nnow\nlater\n```")
+	})
+
+	t.Run("timeout keeps the outputs", func(t *testing.T) {
+		o := testOptions()
+		o.TargetCLI = "sh"
+		o.TimeoutSeconds = 1
+		r, err := executeTarget(ctx, o, `-c "echo started; sleep 10"`, nil, nil, false)
+		assert.NilError(t, err)
+		assert.Equal(t, r.Content, "process killed due to timeout of 1 seconds with stdout:```\nstarted\n```")
+	})
+
+	t.Run("cancel fails with context.Canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(ctx)
+		time.AfterFunc(100*time.Millisecond, cancel)
+		o := testOptions()
+		o.TargetCLI = "sleep"
+		start := time.Now()
+		_, err := executeTarget(ctx, o, "10", nil, nil, false)
+		assert.ErrorIs(t, err, context.Canceled)
+		assert.Assert(t, time.Since(start) < 5*time.Second)
+	})
+
+	t.Run("workspace dirs are set in the environment", func(t *testing.T) {
+		o := testOptions()
+		o.TargetCLI = "sh"
+		r, err := executeTarget(ctx, o, `-c "test -d \"$HOME\" && test -d \"$TMPDIR\" && touch \"$HOME/a\" && echo \"$HOME\" \"$TMPDIR\""`, nil, nil, false)
+		assert.NilError(t, err)
+		dirs := strings.Fields(strings.Trim(r.Content, "`\n"))
+		assert.Equal(t, len(dirs), 2)
+		assert.Equal(t, filepath.Base(dirs[0]), "HOME")
+		assert.Equal(t, filepath.Base(dirs[1]), "TMPDIR")
+		// The workspace is removed after the run.
+		_, err = os.Stat(dirs[0])
+		assert.Assert(t, os.IsNotExist(err))
+	})
+}
+
+func TestPrepareWorkspace(t *testing.T) {
+	o := testOptions()
+	o.EnvVars = []string{"A=1"}
+	// A variable may be named like the working directory.
+	o.EnvWorkspaceDirs = []string{"HOME", "cwd"}
+	workspace := t.TempDir()
+	cwd, env, err := prepareWorkspace(o, workspace)
+	assert.NilError(t, err)
+	assert.Equal(t, cwd, filepath.Join(workspace, "cwd"))
+	dirs := filepath.Join(workspace, "env")
+	assert.DeepEqual(t, env, []string{
+		"A=1",
+		"HOME=" + filepath.Join(dirs, "HOME"),
+		"cwd=" + filepath.Join(dirs, "cwd"),
+	})
+	for _, dir := range []string{cwd, filepath.Join(dirs, "HOME"), filepath.Join(dirs, "cwd")} {
+		info, err := os.Stat(dir)
+		assert.NilError(t, err)
+		assert.Assert(t, info.IsDir())
+	}
+}
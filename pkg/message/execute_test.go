@@ -0,0 +1,51 @@
+package message
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestExecute(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("stdin", func(t *testing.T) {
+		r, err := Execute(ctx, testOptions(), ExecuteRequest{Args: []string{"-n"}, Stdin: []byte("hello\n")})
+		assert.NilError(t, err)
+		assert.Equal(t, r.Content, "```\n     1\thello\n```")
+		assert.Equal(t, len(r.Files), 0)
+	})
+
+	t.Run("input files are returned only if changed", func(t *testing.T) {
+		o := testOptions()
+		o.TargetCLI = "sh"
+		r, err := Execute(ctx, o, ExecuteRequest{
+			Args: []string{"-c", "cat a.txt >b.txt; echo 'changed' >c.txt"},
+			Files: []InputFile{
+				{Name: "a.txt", Content: []byte("hello\n")},
+				{Name: "c.txt", Content: []byte("original\n")},
+			},
+		})
+		assert.NilError(t, err)
+		assert.Equal(t, r.Content, "no output")
+		assert.Equal(t, len(r.Files), 2)
+		assert.Equal(t, r.Files[0].Name, "b.txt")
+		b, err := io.ReadAll(r.Files[0].Reader)
+		assert.NilError(t, err)
+		assert.Equal(t, string(b), "hello\n")
+		assert.Equal(t, r.Files[1].Name, "c.txt")
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		_, err := Execute(ctx, testOptions(), ExecuteRequest{Files: []InputFile{{Name: "../a.txt"}}})
+		assert.ErrorIs(t, err, ErrInvalidArgs)
+		_, err = Execute(ctx, testOptions(), ExecuteRequest{Files: []InputFile{{Name: "a.txt"}, {Name: "a.txt"}}})
+		assert.ErrorIs(t, err, ErrInvalidArgs)
+		o := testOptions()
+		o.MaxTimeoutSeconds = 60
+		_, err = Execute(ctx, o, ExecuteRequest{Args: []string{"timeout=x"}})
+		assert.ErrorIs(t, err, ErrInvalidArgs)
+	})
+}
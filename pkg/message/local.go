@@ -0,0 +1,50 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"regexp"
+
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+)
+
+// localBotID is the user ID of the bot in messages simulated by ExecuteLocal.
+const localBotID snowflake.ID = 1
+
+// localMentionPattern matches `@bot`, the mention of the bot in messages simulated by ExecuteLocal.
+var localMentionPattern = regexp.MustCompile(`@bot\b`)
+
+// errLocal is returned when replying in local mode, which never sends replies.
+var errLocal = errors.New("not available in local mode")
+
+// ExecuteLocal executes the commands in the message content like ExecuteCmds does for a Discord message,
+// without connecting to Discord. The bot is mentioned as `@bot` or `<@1>`, and content without mentions
+// is treated as a direct message, so the default arguments are used. The opts are passed to ExecuteIncoming.
+func ExecuteLocal(ctx context.Context, o *options.Options, content string, opts ...future.Option) iter.Seq[future.Future[*ExecutionResult]] {
+	nickname, _ := o.Discord()
+	content = localMentionPattern.ReplaceAllString(content, "<@"+localBotID.String()+">")
+	m := Incoming{
+		Channel:      ChannelDirect,
+		CommandLines: commandlinesFromMentions(content, localBotID),
+		Text:         content,
+		BotName:      nickname,
+	}
+	if len(m.CommandLines) > 0 {
+		m.Channel, m.Addressed = ChannelShared, true
+	}
+	return ExecuteIncoming(ctx, o, localConversation{}, m, opts...)
+}
+
+// localConversation implements Conversation for ExecuteLocal, which prints the results instead of replying.
+type localConversation struct{}
+
+func (localConversation) Typing(context.Context) error {
+	return nil
+}
+
+func (localConversation) Send(context.Context, *ExecutionResult) (Reply, error) {
+	return nil, errLocal
+}
@@ -0,0 +1,39 @@
+package message
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"gotest.tools/v3/assert"
+)
+
+func TestExecuteLocal(t *testing.T) {
+	ctx := context.Background()
+	o := testOptions()
+
+	t.Run("mention lines", func(t *testing.T) {
+		results := slices.Collect(future.Await(ctx, ExecuteLocal(ctx, o, "@bot\n@bot -n\n```\nhello\n```")))
+		assert.Equal(t, len(results), 2)
+		assert.NilError(t, results[0].Err)
+		assert.Equal(t, results[0].Value.Content, "`cat`\n```\nhello\n```")
+		assert.NilError(t, results[1].Err)
+		assert.Equal(t, results[1].Value.Content, "`cat -n`\n```\n     1\thello\n```")
+	})
+
+	t.Run("direct message", func(t *testing.T) {
+		results := slices.Collect(future.Await(ctx, ExecuteLocal(ctx, o, "```swift\nhello\n```")))
+		assert.Equal(t, len(results), 1)
+		assert.NilError(t, results[0].Err)
+		assert.Equal(t, results[0].Value.Content, "```\nhello\n```")
+	})
+
+	t.Run("help", func(t *testing.T) {
+		results := slices.Collect(future.Await(ctx, ExecuteLocal(ctx, o, "hello")))
+		assert.Equal(t, len(results), 1)
+		assert.NilError(t, results[0].Err)
+		assert.Assert(t, strings.Contains(results[0].Value.Content, "@cat"))
+	})
+}
@@ -1,33 +1,32 @@
-// Package message provides utilities for parsing, executing, and replying to Discord messages.
+// Package message provides utilities for parsing, executing, and replying to chat messages, such as Discord messages.
 package message
 
 import (
-	"bytes"
 	"cmp"
 	"context"
 	"fmt"
-	"io"
 	"iter"
 	"log/slog"
-	"net/http"
 	"regexp"
 	"slices"
-	"strings"
+	"time"
 
 	"github.com/disgoorg/disgo/discord"
 	"github.com/disgoorg/disgo/events"
-	"github.com/disgoorg/disgo/rest"
 	"github.com/disgoorg/snowflake/v2"
 	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/logging"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/metrics"
 	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/replystore"
 	"github.com/norio-nomura/cli_discord_bot2/pkg/xiter"
 )
 
 // --- Public API ---
 
 // ChannelType returns the channel type for the given message.
-func ChannelType(ctx context.Context, e *events.GenericMessage) (discord.ChannelType, error) {
-	ch, err := e.Client().Rest().GetChannel(e.ChannelID, rest.WithCtx(ctx))
+func ChannelType(ctx context.Context, d Discord, e *events.GenericMessage) (discord.ChannelType, error) {
+	ch, err := d.GetChannel(ctx, e.ChannelID)
 	if err != nil {
 		var zero discord.ChannelType
 		return zero, fmt.Errorf("failed to get channel type: %w", err)
@@ -35,108 +34,126 @@ func ChannelType(ctx context.Context, e *events.GenericMessage) (discord.Channel
 	return ch.Type(), nil
 }
 
-// ExecuteCmds executes commands found in a message that mentions the bot.
+// ExecuteCmds executes commands found in a Discord message that mentions the bot.
 // It returns a sequence of Futures, each representing the asynchronous execution result of a command.
-func ExecuteCmds(ctx context.Context, o *options.Options, e *events.GenericMessage) iter.Seq[future.Future[*ExecutionResult]] {
-	// Ensure the context has a timeout for rest operations.
-	restCtx, cancel := o.ContextWithRestTimeout(ctx)
-	defer cancel()
-
-	// If the message should be ignored, return an empty sequence.
-	emptySeq := xiter.SeqOf[future.Future[*ExecutionResult]]()
-	if ShouldIgnore(e) {
-		return emptySeq
-	}
-	// Determine the channel type and set default commands based on it.
-	channelType, err := ChannelType(restCtx, e)
+func ExecuteCmds(ctx context.Context, o *options.Options, d Discord, e *events.GenericMessage, opts ...future.Option) iter.Seq[future.Future[*ExecutionResult]] {
+	m, err := IncomingFromDiscord(ctx, o, d, e)
 	if err != nil {
 		return xiter.SeqOf(future.NewError[*ExecutionResult](err))
 	}
-	defaultCmds := make([]string, 0)
-	switch channelType {
-	case discord.ChannelTypeGuildText, discord.ChannelTypeGuildPublicThread, discord.ChannelTypeGuildPrivateThread:
-		if !mentioning(e, e.Client().ID()) {
-			return emptySeq
-		}
-	case discord.ChannelTypeDM:
-		defaultCmds = append(defaultCmds, "")
-	default:
-		return emptySeq
-	}
-	// detect input from attachments or code blocks
-	input, err := inputFromAttachment(restCtx, e, o.AttachmentExtensionToTreatAsInput)
-	if err != nil {
-		return xiter.SeqOf(future.NewError[*ExecutionResult](err))
-	} else if input == nil {
-		input = inputFromCodeblock(e)
-	}
-	// detect command lines from mentions in the message content
-	cmds := commandlinesFromMentions(e)
-	if len(cmds) == 0 {
-		cmds = defaultCmds
-	}
-	// If multiple commands are provided, we will output the command being executed.
-	outputCmd := len(cmds) > 1
+	return ExecuteIncoming(ctx, o, NewDiscordConversation(o, d, nil, e), m, opts...)
+}
 
-	// If commands are provided, we will send a typing indicator to the channel.
-	if len(cmds) > 0 {
-		_ = e.Client().Rest().SendTyping(e.ChannelID, rest.WithCtx(restCtx))
+// JobLogger returns a logger carrying a new job ID and the IDs of the message, channel, guild and author.
+// It is attached to the context of a job so that one job can be followed through all of its logs.
+func JobLogger(e *events.GenericMessage) *slog.Logger {
+	attrs := []any{
+		slog.String("job.id", logging.NewJobID()),
+		slog.Any("message.id", e.MessageID),
+		slog.Any("channel.id", e.ChannelID),
 	}
-	// Prepare the commands for execution, deduplicating them.
-	seqCmds := xiter.Dedupe(slices.Values(cmds))
-	executeCmdFunc := func(cmd string) future.Future[*ExecutionResult] {
-		var reader io.Reader
-		if input != nil {
-			reader = bytes.NewReader(input)
-		} else if strings.TrimSpace(cmd) == "" {
-			// If the command is empty and no input is provided, return a help message.
-			return future.NewDeferred(func(_ context.Context) (*ExecutionResult, error) {
-				return helpResult(e)
-			})
-		}
-		return future.New(ctx, func(ctx context.Context) (*ExecutionResult, error) {
-			return executeTarget(ctx, o, cmd, reader, outputCmd)
-		})
+	if e.GuildID != nil {
+		attrs = append(attrs, slog.Any("guild.id", *e.GuildID))
+	}
+	if e.Message.Author.ID != 0 {
+		attrs = append(attrs, slog.Any("user.id", e.Message.Author.ID))
 	}
-	return xiter.Map(seqCmds, executeCmdFunc)
+	return slog.Default().With(attrs...)
 }
 
 // GetReplies returns a future for all bot replies to a given message.
-func GetReplies(o *options.Options, e *events.GenericMessage) future.Future[iter.Seq[discord.Message]] {
-	botID := e.Client().ID()
-	return getMessagesWithFilter(o, e, e.ChannelID, func(m discord.Message) bool {
+func GetReplies(o *options.Options, d Discord, s replystore.Store, e *events.GenericMessage) future.Future[iter.Seq[discord.Message]] {
+	botID := d.SelfUser().ID
+	return getMessagesWithFilter(o, d, s, e, e.ChannelID, func(m discord.Message) bool {
 		return m.Author.ID == botID && m.Type == discord.MessageTypeReply && m.MessageReference != nil && *m.MessageReference.MessageID == e.MessageID
 	})
 }
 
 // GetRepliesInThread returns a future for all bot replies in a thread to a given message.
-func GetRepliesInThread(o *options.Options, e *events.GenericMessage) future.Future[iter.Seq[discord.Message]] {
-	botID := e.Client().ID()
-	return getMessagesWithFilter(o, e, e.MessageID, func(m discord.Message) bool {
+func GetRepliesInThread(o *options.Options, d Discord, s replystore.Store, e *events.GenericMessage) future.Future[iter.Seq[discord.Message]] {
+	botID := d.SelfUser().ID
+	return getMessagesWithFilter(o, d, s, e, e.MessageID, func(m discord.Message) bool {
 		return m.Author.ID == botID && m.Type == discord.MessageTypeDefault
 	})
 }
 
-func getMessagesWithFilter(o *options.Options, e *events.GenericMessage, channelID snowflake.ID, filterFunc func(discord.Message) bool) future.Future[iter.Seq[discord.Message]] {
+// getMessagesWithFilter returns a future for the replies to the message in the given channel.
+// Replies recorded in the store are used as is; otherwise the channel history is scanned with filterFunc
+// and the replies found are recorded.
+func getMessagesWithFilter(o *options.Options, d Discord, s replystore.Store, e *events.GenericMessage, channelID snowflake.ID, filterFunc func(discord.Message) bool) future.Future[iter.Seq[discord.Message]] {
+	// Look up the store now rather than when the future runs, so that replies sent meanwhile are not included.
+	if recorded, ok := s.Replies(e.MessageID); ok {
+		var replies []discord.Message
+		for _, r := range recorded {
+			if r.ChannelID == channelID {
+				replies = append(replies, discord.Message{ID: r.MessageID, ChannelID: r.ChannelID})
+			}
+		}
+		return future.NewValue(slices.Values(replies))
+	}
 	return future.NewDeferred(func(ctx context.Context) (iter.Seq[discord.Message], error) {
 		// Ensure the context has a timeout for rest operations.
 		ctx, cancel := o.ContextWithRestTimeout(ctx)
 		defer cancel()
-		messages, err := e.Client().Rest().GetMessages(channelID, 0, 0, e.MessageID, 0, rest.WithCtx(ctx))
+		sorted, err := scanMessages(ctx, d, channelID, e.MessageID, filterFunc)
+		if err != nil {
+			return nil, err
+		}
+		logger := logging.FromContext(ctx)
+		logger.Debug("Found replies", slog.Any("channel.id", channelID), slog.Int("count", len(sorted)))
+		for _, m := range sorted {
+			if !recordReply(logger, s, e.MessageID, m) {
+				break
+			}
+		}
+		return slices.Values(sorted), nil
+	})
+}
+
+const (
+	// replyScanPageSize is the number of messages fetched per request, which is the maximum allowed by Discord.
+	replyScanPageSize = 100
+	// replyScanMaxMessages bounds the number of messages scanned for replies.
+	replyScanMaxMessages = 1000
+	// replyScanMaxAge bounds the scan to messages posted within this duration after the source message.
+	replyScanMaxAge = 24 * time.Hour
+)
+
+// scanMessages pages forward through the messages in the channel after the source message,
+// and returns those matching filterFunc in ascending order of ID.
+// It stops at the end of the channel, or after replyScanMaxMessages messages or replyScanMaxAge.
+func scanMessages(ctx context.Context, d Discord, channelID, source snowflake.ID, filterFunc func(discord.Message) bool) ([]discord.Message, error) {
+	deadline := source.Time().Add(replyScanMaxAge)
+	var found []discord.Message
+	after := source
+	for scanned := 0; scanned < replyScanMaxMessages; {
+		start := time.Now()
+		page, err := d.GetMessages(ctx, channelID, after, replyScanPageSize)
+		metrics.ObserveRest("GetMessages", start, err)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get messages in channel %s: %w", channelID, err)
 		}
-		replies := xiter.Filter(slices.Values(messages), filterFunc)
-		cmpMessage := func(m1, m2 discord.Message) int {
-			return cmp.Compare(m1.ID, m2.ID)
+		scanned += len(page)
+		// Discord returns the page newest first, so take the maximum ID rather than relying on the order.
+		for _, m := range page {
+			after = max(after, m.ID)
+			if filterFunc(m) {
+				found = append(found, m)
+			}
 		}
-		return slices.Values(slices.SortedFunc(replies, cmpMessage)), nil
+		if len(page) < replyScanPageSize || after.Time().After(deadline) {
+			break
+		}
+	}
+	slices.SortFunc(found, func(m1, m2 discord.Message) int {
+		return cmp.Compare(m1.ID, m2.ID)
 	})
+	return found, nil
 }
 
 // SendReply sends a reply to the given message with the provided execution result.
 // Returns a future for the sent Discord message.
-func SendReply(o *options.Options, e *events.GenericMessage, r *ExecutionResult) future.Future[*discord.Message] {
+func SendReply(o *options.Options, d Discord, s replystore.Store, e *events.GenericMessage, r *ExecutionResult) future.Future[*discord.Message] {
 	if r == nil {
 		return future.NewValue[*discord.Message](nil)
 	}
@@ -153,7 +170,15 @@ func SendReply(o *options.Options, e *events.GenericMessage, r *ExecutionResult)
 		// Ensure the context has a timeout for rest operations.
 		ctx, cancel := o.ContextWithRestTimeout(ctx)
 		defer cancel()
-		return e.Client().Rest().CreateMessage(channelID, reply, rest.WithCtx(ctx))
+		start := time.Now()
+		m, err := d.CreateMessage(ctx, channelID, reply)
+		metrics.ObserveRest("SendReply", start, err)
+		if err == nil {
+			logger := logging.FromContext(ctx)
+			logger.Info("Sent reply", slog.Any("reply.id", m.ID))
+			recordReply(logger, s, e.MessageID, *m)
+		}
+		return m, err
 	})
 }
 
@@ -169,7 +194,7 @@ func ShouldIgnore(e *events.GenericMessage) bool {
 
 // UpdateMessage updates the given Discord message with the new execution result.
 // Returns a future for the updated message.
-func UpdateMessage(o *options.Options, e *events.GenericMessage, m discord.Message, r *ExecutionResult) future.Future[*discord.Message] {
+func UpdateMessage(o *options.Options, d Discord, e *events.GenericMessage, m discord.Message, r *ExecutionResult) future.Future[*discord.Message] {
 	if r == nil {
 		return future.NewValue[*discord.Message](nil)
 	}
@@ -178,37 +203,66 @@ func UpdateMessage(o *options.Options, e *events.GenericMessage, m discord.Messa
 		// Ensure the context has a timeout for rest operations.
 		ctx, cancel := o.ContextWithRestTimeout(ctx)
 		defer cancel()
-		return e.Client().Rest().UpdateMessage(m.ChannelID, m.ID, msg, rest.WithCtx(ctx))
+		start := time.Now()
+		updated, err := d.UpdateMessage(ctx, m.ChannelID, m.ID, msg)
+		metrics.ObserveRest("UpdateMessage", start, err)
+		if err == nil {
+			logging.FromContext(ctx).Info("Updated reply", slog.Any("reply.id", m.ID))
+		}
+		return updated, err
 	})
 }
 
-// DeleteMessage deletes the specified message in the given channel.
+// DeleteMessage deletes the specified reply to the given message and forgets it in the store.
 // Returns a future that resolves when the deletion is complete.
-func DeleteMessage(o *options.Options, e *events.GenericMessage, id snowflake.ID) future.Future[any] {
+func DeleteMessage(o *options.Options, d Discord, s replystore.Store, e *events.GenericMessage, id snowflake.ID) future.Future[any] {
 	return future.NewDeferred(func(ctx context.Context) (any, error) {
 		// Ensure the context has a timeout for rest operations.
 		ctx, cancel := o.ContextWithRestTimeout(ctx)
 		defer cancel()
-		if err := e.Client().Rest().DeleteMessage(e.ChannelID, id, rest.WithCtx(ctx)); err != nil {
+		start := time.Now()
+		err := d.DeleteMessage(ctx, e.ChannelID, id)
+		metrics.ObserveRest("DeleteMessage", start, err)
+		if err != nil {
 			return nil, fmt.Errorf("failed to delete message %s: %w", id, err)
 		}
+		logger := logging.FromContext(ctx)
+		logger.Info("Deleted reply", slog.Any("reply.id", id))
+		if err := s.Remove(e.MessageID, id); err != nil {
+			logger.Warn("Failed to forget reply", slog.Any("reply.id", id), slog.Any("err", err))
+		}
 		return nil, nil
 	})
 }
 
 // --- Private helpers ---
 
+// recordReply records a reply to the source message in the store, and returns false if it failed.
+// Failing to record is not fatal since the replies can be found by scanning the channel history.
+func recordReply(logger *slog.Logger, s replystore.Store, source snowflake.ID, m discord.Message) bool {
+	if err := s.Add(source, replystore.Reply{ChannelID: m.ChannelID, MessageID: m.ID}); err != nil {
+		logger.Warn("Failed to record reply", slog.Any("reply.id", m.ID), slog.Any("err", err))
+		// The recorded replies would be incomplete; forget them so that the channel is scanned for all of them.
+		if err := s.Forget(source); err != nil {
+			logger.Warn("Failed to forget replies", slog.Any("message.id", source), slog.Any("err", err))
+		}
+		return false
+	}
+	return true
+}
+
 // commandlinesFromMentions extracts command lines from mention lines in the message content.
 //
-//	e: Discord message event
+//	content: Discord message content
+//	botID: user ID of the bot
 //
 // Returns a slice of command line strings, or nil if none found.
-func commandlinesFromMentions(e *events.GenericMessage) []string {
-	if e.Message.Content == "" {
+func commandlinesFromMentions(content string, botID snowflake.ID) []string {
+	if content == "" {
 		return nil
 	}
-	mentionLinePattern := regexp.MustCompile("(?ms)<@!?" + e.Client().ID().String() + ">(.*?)(?:```|$)")
-	matches := mentionLinePattern.FindAllStringSubmatch(e.Message.Content, -1)
+	mentionLinePattern := regexp.MustCompile("(?ms)<@!?" + botID.String() + ">(.*?)(?:```|$)")
+	matches := mentionLinePattern.FindAllStringSubmatch(content, -1)
 	if len(matches) == 0 {
 		return nil
 	}
@@ -222,16 +276,15 @@ func commandlinesFromMentions(e *events.GenericMessage) []string {
 
 // helpResult returns a default help message for the bot, formatted with code blocks.
 // It includes usage instructions and an example of how to provide input.
-func helpResult(e *events.GenericMessage) (*ExecutionResult, error) {
+func helpResult(botName string) (*ExecutionResult, error) {
 	const tripleBackticks = "```"
 	const zeroWithSpace = "\u200b"
 	// To embed triple backticks in a code block, we need to use zero-width spaces
 	const tripleBackticksForCodeblock = "`" + zeroWithSpace + "`" + zeroWithSpace + "`"
-	user, _ := e.Client().Caches().SelfUser()
 	return &ExecutionResult{
 		Content: tripleBackticks + `
 Usage:
-@` + user.Username + `
+@` + botName + `
 ` + tripleBackticksForCodeblock + `
 [contents for standard input]
 ` + tripleBackticksForCodeblock + `
@@ -239,57 +292,17 @@ Usage:
 	}, nil
 }
 
-// inputFromAttachment returns an io.Reader for the first attachment in the message
-// whose filename matches the given extension. Returns (nil, nil) if not found.
-//
-// ctx: context for the request
-//
-//	e: Discord message event
-//	extension: file extension to match (e.g. ".txt")
-func inputFromAttachment(ctx context.Context, e *events.GenericMessage, extension string) ([]byte, error) {
-	hasTargetExtension := func(a discord.Attachment) bool {
-		return strings.HasSuffix(a.Filename, extension)
-	}
-	next, stop := iter.Pull(xiter.Filter(slices.Values(e.Message.Attachments), hasTargetExtension))
-	defer stop()
-	attachment, ok := next()
-	if !ok {
-		return nil, nil // No matching attachment found
-	}
-	req, err := http.NewRequestWithContext(ctx, "GET", attachment.URL, http.NoBody)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request for attachment %s: %w", attachment.Filename, err)
-	}
-	resp, err := e.Client().Rest().HTTPClient().Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to download attachment %s: %w", attachment.Filename, err)
-	}
-	defer func() {
-		if err := resp.Body.Close(); err != nil {
-			slog.Error("Failed to close response body", slog.String("attachment", attachment.Filename), slog.Any("error", err))
-		}
-	}()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("failed to download attachment %s: %s", attachment.Filename, resp.Status)
-	}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read attachment %s: %w", attachment.Filename, err)
-	}
-	return body, nil
-}
-
-// inputFromCodeblock extracts the first code block from the message content.
+// inputFromCodeblock extracts the first code block from the message text.
 //
-//	e: Discord message event
+//	text: text of the message
 //
-// Returns an io.Reader for the code block, or nil if no code block is found.
-func inputFromCodeblock(e *events.GenericMessage) []byte {
-	if e.Message.Content == "" {
+// Returns the content of the code block, or nil if no code block is found.
+func inputFromCodeblock(text string) []byte {
+	if text == "" {
 		return nil
 	}
 	re := regexp.MustCompile("(?ms)```(?:.*?\\n)?(.*?)```")
-	matches := re.FindStringSubmatch(e.Message.Content)
+	matches := re.FindStringSubmatch(text)
 	if len(matches) == 0 {
 		return nil
 	}
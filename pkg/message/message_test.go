@@ -0,0 +1,234 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/events"
+	"github.com/disgoorg/snowflake/v2"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/future"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/message/messagetest"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/options"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/replystore"
+	"github.com/norio-nomura/cli_discord_bot2/pkg/xiter"
+	"gotest.tools/v3/assert"
+)
+
+var _ Discord = (*messagetest.Discord)(nil)
+
+const (
+	botID     snowflake.ID = 100
+	channelID snowflake.ID = 200
+)
+
+// testOptions returns options running `cat` with the defaults.
+func testOptions() *options.Options {
+	return &options.Options{
+		EnvCommand:                         []string{"/usr/bin/env", "-i", "PATH=" + os.Getenv("PATH")},
+		EnvWorkspaceDirs:                   []string{"HOME", "TMPDIR"},
+		NumberOfLinesToEmbedOutput:         20,
+		NumberOfLinesToEmbedUploadedOutput: 3,
+		RestTimeoutSeconds:                 10,
+		TargetCLI:                          "cat",
+		TimeoutSeconds:                     10,
+	}
+}
+
+// newChannel returns a source message and n messages posted every interval after it.
+// Messages whose index is in replies are authored by the bot.
+func newChannel(n int, interval time.Duration, replies ...int) (snowflake.ID, *messagetest.Discord) {
+	d := messagetest.NewDiscord(discord.User{ID: botID})
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	source := d.AddMessage(discord.Message{ID: snowflake.New(start), ChannelID: channelID}).ID
+	for i := range n {
+		m := discord.Message{ID: snowflake.New(start.Add(time.Duration(i+1) * interval)), ChannelID: channelID}
+		m.Author.Bot = slices.Contains(replies, i)
+		d.AddMessage(m)
+	}
+	return source, d
+}
+
+func isBot(m discord.Message) bool { return m.Author.Bot }
+
+func TestScanMessages(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("pages until the end of channel", func(t *testing.T) {
+		source, d := newChannel(250, time.Second, 5, 150, 240)
+		found, err := scanMessages(ctx, d, channelID, source, isBot)
+		assert.NilError(t, err)
+		assert.Equal(t, len(d.CallsOf("GetMessages")), 3)
+		messages := d.Messages(channelID)[1:]
+		assert.DeepEqual(t, found, []discord.Message{messages[5], messages[150], messages[240]})
+	})
+
+	t.Run("bounded by count", func(t *testing.T) {
+		source, d := newChannel(replyScanMaxMessages+200, time.Second, 10, replyScanMaxMessages+10)
+		found, err := scanMessages(ctx, d, channelID, source, isBot)
+		assert.NilError(t, err)
+		assert.Equal(t, len(d.CallsOf("GetMessages")), replyScanMaxMessages/replyScanPageSize)
+		assert.DeepEqual(t, found, []discord.Message{d.Messages(channelID)[11]})
+	})
+
+	t.Run("bounded by age", func(t *testing.T) {
+		source, d := newChannel(500, time.Hour, 1, 400)
+		found, err := scanMessages(ctx, d, channelID, source, isBot)
+		assert.NilError(t, err)
+		assert.Equal(t, len(d.CallsOf("GetMessages")), 1)
+		assert.DeepEqual(t, found, []discord.Message{d.Messages(channelID)[2]})
+	})
+
+	t.Run("error", func(t *testing.T) {
+		source, d := newChannel(1, time.Second)
+		d.FailOn("GetMessages", errors.New("boom"))
+		_, err := scanMessages(ctx, d, channelID, source, isBot)
+		assert.ErrorContains(t, err, "boom")
+	})
+}
+
+// newMessageEvent posts a message with the content in a channel of the type, and returns its event.
+func newMessageEvent(d *messagetest.Discord, typ discord.ChannelType, content string, mentions ...discord.User) *events.GenericMessage {
+	d.AddChannel(channelID, typ)
+	m := d.AddMessage(discord.Message{ChannelID: channelID, Content: content, Mentions: mentions})
+	return &events.GenericMessage{
+		GenericEvent: events.NewGenericEvent(nil, 0, 0),
+		MessageID:    m.ID,
+		Message:      m,
+		ChannelID:    channelID,
+	}
+}
+
+func TestExecuteCmds(t *testing.T) {
+	ctx := context.Background()
+	o := testOptions()
+	bot := discord.User{ID: botID, Username: "bot"}
+
+	t.Run("help in direct message", func(t *testing.T) {
+		d := messagetest.NewDiscord(bot)
+		e := newMessageEvent(d, discord.ChannelTypeDM, "hello")
+		results := slices.Collect(future.Await(ctx, ExecuteCmds(ctx, o, d, e)))
+		assert.Equal(t, len(results), 1)
+		assert.NilError(t, results[0].Err)
+		assert.Assert(t, strings.Contains(results[0].Value.Content, "@bot"))
+	})
+
+	t.Run("ignored without mention in guild", func(t *testing.T) {
+		d := messagetest.NewDiscord(bot)
+		e := newMessageEvent(d, discord.ChannelTypeGuildText, "hello")
+		results := slices.Collect(future.Await(ctx, ExecuteCmds(ctx, o, d, e)))
+		assert.Equal(t, len(results), 0)
+		assert.Equal(t, len(d.CallsOf("SendTyping")), 0)
+	})
+
+	t.Run("runs each mention line with code block input", func(t *testing.T) {
+		d := messagetest.NewDiscord(bot)
+		e := newMessageEvent(d, discord.ChannelTypeGuildText, "<@100>\n<@100> -n\n```\nhello\n```", bot)
+		results := slices.Collect(future.Await(ctx, ExecuteCmds(ctx, o, d, e)))
+		assert.Equal(t, len(results), 2)
+		assert.NilError(t, results[0].Err)
+		assert.Equal(t, results[0].Value.Content, "`cat`\n```\nhello\n```")
+		assert.NilError(t, results[1].Err)
+		assert.Equal(t, results[1].Value.Content, "`cat -n`\n```\n     1\thello\n```")
+		assert.Equal(t, len(d.CallsOf("SendTyping")), 1)
+	})
+
+	t.Run("attachment input", func(t *testing.T) {
+		o := testOptions()
+		o.AttachmentExtensionToTreatAsInput = ".txt"
+		d := messagetest.NewDiscord(bot)
+		e := newMessageEvent(d, discord.ChannelTypeGuildText, "<@100>", bot)
+		e.Message.Attachments = []discord.Attachment{{Filename: "input.txt", URL: "https://cdn.example/input.txt"}}
+		d.AddAttachment("https://cdn.example/input.txt", []byte("from file\n"))
+		results := slices.Collect(future.Await(ctx, ExecuteCmds(ctx, o, d, e)))
+		assert.Equal(t, len(results), 1)
+		assert.Equal(t, results[0].Value.Content, "```\nfrom file\n```")
+	})
+
+	t.Run("channel error", func(t *testing.T) {
+		d := messagetest.NewDiscord(bot)
+		e := newMessageEvent(d, discord.ChannelTypeGuildText, "<@100>", bot)
+		d.FailOn("GetChannel", errors.New("boom"))
+		results := slices.Collect(future.Await(ctx, ExecuteCmds(ctx, o, d, e)))
+		assert.Equal(t, len(results), 1)
+		assert.ErrorContains(t, results[0].Err, "boom")
+	})
+}
+
+func TestSyncReplies_StaleStore(t *testing.T) {
+	ctx := context.Background()
+	o := testOptions()
+	bot := discord.User{ID: botID, Username: "bot"}
+	d := messagetest.NewDiscord(bot)
+	e := newMessageEvent(d, discord.ChannelTypeGuildText, "<@100>", bot)
+	s := replystore.NewMemory(0)
+	// The store records two replies which have been deleted by someone else.
+	for _, id := range []snowflake.ID{e.MessageID + 1, e.MessageID + 2} {
+		assert.NilError(t, s.Add(e.MessageID, replystore.Reply{ChannelID: channelID, MessageID: id}))
+	}
+	c := NewDiscordConversation(o, d, s, e)
+	replies, err := GetReplies(o, d, s, e).Await(ctx)
+	assert.NilError(t, err)
+
+	// The first is replaced by a new reply, and deleting the second succeeds.
+	results := slices.All([]future.Result[*ExecutionResult]{{Value: &ExecutionResult{Content: "hello"}}})
+	assert.NilError(t, SyncReplies(ctx, c, results, c.Replies(replies), xiter.SeqOf[Reply]()))
+	assert.Equal(t, len(d.CallsOf("UpdateMessage")), 1)
+	assert.Equal(t, len(d.CallsOf("DeleteMessage")), 1)
+	created := d.CallsOf("CreateMessage")
+	assert.Equal(t, len(created), 1)
+	assert.Equal(t, created[0].Content, "hello")
+	recorded, ok := s.Replies(e.MessageID)
+	assert.Assert(t, ok)
+	assert.DeepEqual(t, recorded, []replystore.Reply{{ChannelID: channelID, MessageID: created[0].MessageID}})
+}
+
+// failingStore is a Memory store whose Add fails after recording in memory while fail is set,
+// like a File store failing to write its journal.
+type failingStore struct {
+	*replystore.Memory
+	fail bool
+}
+
+func (s *failingStore) Add(source snowflake.ID, reply replystore.Reply) error {
+	if err := s.Memory.Add(source, reply); err != nil {
+		return err
+	}
+	if s.fail {
+		return errors.New("disk full")
+	}
+	return nil
+}
+
+func TestGetReplies_IncompleteStore(t *testing.T) {
+	ctx := context.Background()
+	o := testOptions()
+	bot := discord.User{ID: botID, Username: "bot"}
+	d := messagetest.NewDiscord(bot)
+	e := newMessageEvent(d, discord.ChannelTypeGuildText, "<@100>", bot)
+	s := &failingStore{Memory: replystore.NewMemory(0)}
+
+	// The first reply is recorded, but the second is not.
+	_, err := SendReply(o, d, s, e, &ExecutionResult{Content: "first"}).Await(ctx)
+	assert.NilError(t, err)
+	s.fail = true
+	_, err = SendReply(o, d, s, e, &ExecutionResult{Content: "second"}).Await(ctx)
+	assert.NilError(t, err)
+	_, ok := s.Replies(e.MessageID)
+	assert.Assert(t, !ok)
+
+	// Both replies are found by scanning the channel instead.
+	s.fail = false
+	replies, err := GetReplies(o, d, s, e).Await(ctx)
+	assert.NilError(t, err)
+	assert.Equal(t, len(slices.Collect(replies)), 2)
+	assert.Equal(t, len(d.CallsOf("GetMessages")), 1)
+	recorded, ok := s.Replies(e.MessageID)
+	assert.Assert(t, ok)
+	assert.Equal(t, len(recorded), 2)
+}
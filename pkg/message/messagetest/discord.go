@@ -0,0 +1,341 @@
+// Package messagetest provides an in-process fake of the Discord API used by package message.
+package messagetest
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/disgoorg/disgo/discord"
+	"github.com/disgoorg/disgo/rest"
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// Call records a call to Discord.
+type Call struct {
+	// Op is the name of the method, such as "CreateMessage".
+	Op        string
+	ChannelID snowflake.ID
+	// MessageID is the message updated or deleted, the reply created, or the message after which messages are got.
+	MessageID snowflake.ID
+	// Content is the content of the message created or updated.
+	Content string
+}
+
+// channel is a simulated channel.
+type channel struct {
+	typ      discord.ChannelType
+	parentID snowflake.ID
+	messages []discord.Message // ascending order of ID
+}
+
+// Discord is a fake of message.Discord that simulates channels, threads and messages in memory.
+// It records every call, and can be made to fail any operation.
+type Discord struct {
+	mu          sync.Mutex
+	self        discord.User
+	channels    map[snowflake.ID]*channel
+	attachments map[string][]byte
+	errs        map[string]error
+	calls       []Call
+	lastID      snowflake.ID
+}
+
+// NewDiscord returns a Discord without channels, acting as the bot user self.
+func NewDiscord(self discord.User) *Discord {
+	self.Bot = true
+	return &Discord{
+		self:        self,
+		channels:    make(map[snowflake.ID]*channel),
+		attachments: make(map[string][]byte),
+		errs:        make(map[string]error),
+		lastID:      snowflake.New(time.Now()),
+	}
+}
+
+// AddChannel adds a channel of the given type.
+func (d *Discord) AddChannel(id snowflake.ID, typ discord.ChannelType) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.channels[id] = &channel{typ: typ}
+}
+
+// AddThread adds a public thread started from the message in the parent channel.
+// Like Discord, the thread ID is the message ID, and the message gets MessageFlagHasThread.
+func (d *Discord) AddThread(parentID, messageID snowflake.ID) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.channels[messageID] = &channel{typ: discord.ChannelTypeGuildPublicThread, parentID: parentID}
+	if parent, ok := d.channels[parentID]; ok {
+		if i, found := findMessage(parent.messages, messageID); found {
+			parent.messages[i].Flags = parent.messages[i].Flags.Add(discord.MessageFlagHasThread)
+		}
+	}
+}
+
+// AddMessage posts m in its channel without recording a call, and returns it.
+// A new ID is assigned if m has none; otherwise the ID must be newer than the messages posted before.
+func (d *Discord) AddMessage(m discord.Message) discord.Message {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if m.ID == 0 {
+		m.ID = d.newID()
+	} else {
+		d.lastID = max(d.lastID, m.ID)
+	}
+	if m.CreatedAt.IsZero() {
+		m.CreatedAt = m.ID.Time()
+	}
+	ch := d.channel(m.ChannelID)
+	ch.messages = append(ch.messages, m)
+	slices.SortFunc(ch.messages, func(m1, m2 discord.Message) int { return cmp.Compare(m1.ID, m2.ID) })
+	return m
+}
+
+// AddAttachment makes the content downloadable from url.
+func (d *Discord) AddAttachment(url string, content []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.attachments[url] = content
+}
+
+// FailOn makes subsequent calls of the operation, such as "CreateMessage", return err.
+// A nil err makes the operation succeed again.
+func (d *Discord) FailOn(op string, err error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err == nil {
+		delete(d.errs, op)
+	} else {
+		d.errs[op] = err
+	}
+}
+
+// Calls returns the calls recorded so far.
+func (d *Discord) Calls() []Call {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return slices.Clone(d.calls)
+}
+
+// CallsOf returns the calls of the operation recorded so far.
+func (d *Discord) CallsOf(op string) []Call {
+	return slices.DeleteFunc(d.Calls(), func(c Call) bool { return c.Op != op })
+}
+
+// Messages returns the messages in the channel in ascending order of ID.
+func (d *Discord) Messages(channelID snowflake.ID) []discord.Message {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if ch, ok := d.channels[channelID]; ok {
+		return slices.Clone(ch.messages)
+	}
+	return nil
+}
+
+// SelfUser implements message.Discord.
+func (d *Discord) SelfUser() discord.User {
+	return d.self
+}
+
+// GetChannel implements message.Discord.
+func (d *Discord) GetChannel(_ context.Context, channelID snowflake.ID) (discord.Channel, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record(Call{Op: "GetChannel", ChannelID: channelID}); err != nil {
+		return nil, err
+	}
+	ch, ok := d.channels[channelID]
+	if !ok {
+		return nil, notFound("Unknown Channel")
+	}
+	data, err := json.Marshal(map[string]any{"id": channelID, "type": ch.typ, "parent_id": ch.parentID})
+	if err != nil {
+		return nil, err
+	}
+	var u discord.UnmarshalChannel
+	if err := json.Unmarshal(data, &u); err != nil {
+		return nil, err
+	}
+	return u.Channel, nil
+}
+
+// GetMessage implements message.Discord.
+func (d *Discord) GetMessage(_ context.Context, channelID, messageID snowflake.ID) (*discord.Message, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record(Call{Op: "GetMessage", ChannelID: channelID, MessageID: messageID}); err != nil {
+		return nil, err
+	}
+	ch, ok := d.channels[channelID]
+	if !ok {
+		return nil, notFound("Unknown Channel")
+	}
+	i, found := findMessage(ch.messages, messageID)
+	if !found {
+		return nil, notFound("Unknown Message")
+	}
+	m := ch.messages[i]
+	return &m, nil
+}
+
+// GetMessages implements message.Discord.
+func (d *Discord) GetMessages(_ context.Context, channelID, after snowflake.ID, limit int) ([]discord.Message, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record(Call{Op: "GetMessages", ChannelID: channelID, MessageID: after}); err != nil {
+		return nil, err
+	}
+	ch, ok := d.channels[channelID]
+	if !ok {
+		return nil, notFound("Unknown Channel")
+	}
+	i, found := findMessage(ch.messages, after)
+	if found {
+		i++
+	}
+	page := slices.Clone(ch.messages[i:min(i+limit, len(ch.messages))])
+	slices.Reverse(page) // newest first
+	return page, nil
+}
+
+// CreateMessage implements message.Discord.
+func (d *Discord) CreateMessage(_ context.Context, channelID snowflake.ID, create discord.MessageCreate) (*discord.Message, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	id := d.newID()
+	if err := d.record(Call{Op: "CreateMessage", ChannelID: channelID, MessageID: id, Content: create.Content}); err != nil {
+		return nil, err
+	}
+	ch, ok := d.channels[channelID]
+	if !ok {
+		return nil, notFound("Unknown Channel")
+	}
+	m := discord.Message{
+		ID:        id,
+		ChannelID: channelID,
+		Author:    d.self,
+		Content:   create.Content,
+		CreatedAt: id.Time(),
+		Type:      discord.MessageTypeDefault,
+	}
+	if ref := create.MessageReference; ref != nil {
+		if _, found := findMessage(ch.messages, *ref.MessageID); !found {
+			return nil, notFound("Unknown Message")
+		}
+		m.Type = discord.MessageTypeReply
+		m.MessageReference = &discord.MessageReference{MessageID: ref.MessageID, ChannelID: &channelID}
+	}
+	ch.messages = append(ch.messages, m)
+	return &m, nil
+}
+
+// UpdateMessage implements message.Discord.
+func (d *Discord) UpdateMessage(_ context.Context, channelID, messageID snowflake.ID, update discord.MessageUpdate) (*discord.Message, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var content string
+	if update.Content != nil {
+		content = *update.Content
+	}
+	if err := d.record(Call{Op: "UpdateMessage", ChannelID: channelID, MessageID: messageID, Content: content}); err != nil {
+		return nil, err
+	}
+	ch, ok := d.channels[channelID]
+	if !ok {
+		return nil, notFound("Unknown Channel")
+	}
+	i, found := findMessage(ch.messages, messageID)
+	if !found {
+		return nil, notFound("Unknown Message")
+	}
+	if update.Content != nil {
+		ch.messages[i].Content = content
+	}
+	now := time.Now()
+	ch.messages[i].EditedTimestamp = &now
+	m := ch.messages[i]
+	return &m, nil
+}
+
+// DeleteMessage implements message.Discord.
+func (d *Discord) DeleteMessage(_ context.Context, channelID, messageID snowflake.ID) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record(Call{Op: "DeleteMessage", ChannelID: channelID, MessageID: messageID}); err != nil {
+		return err
+	}
+	ch, ok := d.channels[channelID]
+	if !ok {
+		return notFound("Unknown Channel")
+	}
+	i, found := findMessage(ch.messages, messageID)
+	if !found {
+		return notFound("Unknown Message")
+	}
+	ch.messages = slices.Delete(ch.messages, i, i+1)
+	return nil
+}
+
+// SendTyping implements message.Discord.
+func (d *Discord) SendTyping(_ context.Context, channelID snowflake.ID) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.record(Call{Op: "SendTyping", ChannelID: channelID})
+}
+
+// DownloadAttachment implements message.Discord.
+func (d *Discord) DownloadAttachment(_ context.Context, a discord.Attachment) ([]byte, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.record(Call{Op: "DownloadAttachment"}); err != nil {
+		return nil, err
+	}
+	content, ok := d.attachments[a.URL]
+	if !ok {
+		return nil, fmt.Errorf("failed to download attachment %s: 404 Not Found", a.Filename)
+	}
+	return slices.Clone(content), nil
+}
+
+// record appends the call, and returns the error set by FailOn for its operation.
+func (d *Discord) record(c Call) error {
+	d.calls = append(d.calls, c)
+	return d.errs[c.Op]
+}
+
+// channel returns the channel with the ID, adding a guild text channel if it does not exist.
+func (d *Discord) channel(id snowflake.ID) *channel {
+	ch, ok := d.channels[id]
+	if !ok {
+		ch = &channel{typ: discord.ChannelTypeGuildText}
+		d.channels[id] = ch
+	}
+	return ch
+}
+
+// newID returns a message ID newer than all the others.
+func (d *Discord) newID() snowflake.ID {
+	d.lastID = max(d.lastID+1, snowflake.New(time.Now()))
+	return d.lastID
+}
+
+// findMessage finds the message with the ID in messages sorted in ascending order of ID.
+func findMessage(messages []discord.Message, id snowflake.ID) (int, bool) {
+	return slices.BinarySearchFunc(messages, id, func(m discord.Message, id snowflake.ID) int {
+		return cmp.Compare(m.ID, id)
+	})
+}
+
+// notFound returns the error Discord responds with for an unknown resource.
+func notFound(message string) error {
+	return &rest.Error{
+		Response: &http.Response{StatusCode: http.StatusNotFound, Status: "404 Not Found"},
+		Message:  message,
+	}
+}
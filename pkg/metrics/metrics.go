@@ -0,0 +1,160 @@
+// Package metrics provides Prometheus metrics for executions, REST calls and events.
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace is the prefix of all metric names.
+const namespace = "cli_discord_bot"
+
+var (
+	registry = prometheus.NewRegistry()
+
+	executions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "executions_total",
+		Help:      "Number of target CLI executions by target, exit status and whether they timed out.",
+	}, []string{"target", "status", "timeout"})
+
+	executionDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "execution_duration_seconds",
+		Help:      "Duration of target CLI executions.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"target"})
+
+	outputBytes = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "execution_output_bytes",
+		Help:      "Size of target CLI outputs by stream.",
+		Buckets:   prometheus.ExponentialBuckets(64, 4, 8),
+	}, []string{"target", "stream"})
+
+	restDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "rest_request_duration_seconds",
+		Help:      "Latency of Discord REST calls by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+
+	restErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rest_errors_total",
+		Help:      "Number of failed Discord REST calls by operation.",
+	}, []string{"operation"})
+
+	events = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_total",
+		Help:      "Number of Discord gateway events received by type.",
+	}, []string{"type"})
+
+	inFlightMessages = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "in_flight_messages",
+		Help:      "Number of messages whose events are currently being processed.",
+	})
+
+	shards = &shardCollector{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "", "shard_status"),
+			"Gateway status of each shard, always 1 with the current status as a label.",
+			[]string{"shard", "status"}, nil,
+		),
+	}
+)
+
+// shardCollector collects the gateway status of each shard on every scrape.
+type shardCollector struct {
+	desc     *prometheus.Desc
+	mu       sync.Mutex
+	statuses func() map[int]string
+}
+
+// Describe implements prometheus.Collector.
+func (c *shardCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.desc
+}
+
+// Collect implements prometheus.Collector.
+func (c *shardCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	statuses := c.statuses
+	c.mu.Unlock()
+	if statuses == nil {
+		return
+	}
+	for id, status := range statuses() {
+		ch <- prometheus.MustNewConstMetric(c.desc, prometheus.GaugeValue, 1, strconv.Itoa(id), status)
+	}
+}
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		executions,
+		executionDuration,
+		outputBytes,
+		restDuration,
+		restErrors,
+		events,
+		inFlightMessages,
+		shards,
+	)
+}
+
+// Handler returns an HTTP handler serving the metrics in the Prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveExecution records a finished target CLI execution.
+// exitCode is the exit code of the process, or -1 if it did not start or was killed by a signal.
+func ObserveExecution(target string, exitCode int, timedOut bool, duration time.Duration) {
+	status := "signaled"
+	if exitCode >= 0 {
+		status = strconv.Itoa(exitCode)
+	}
+	executions.WithLabelValues(target, status, strconv.FormatBool(timedOut)).Inc()
+	executionDuration.WithLabelValues(target).Observe(duration.Seconds())
+}
+
+// ObserveOutput records the size of an output stream of a target CLI execution.
+func ObserveOutput(target, stream string, size int) {
+	outputBytes.WithLabelValues(target, stream).Observe(float64(size))
+}
+
+// ObserveRest records the latency of a Discord REST call started at start, and counts it as failed if err is not nil.
+func ObserveRest(operation string, start time.Time, err error) {
+	restDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		restErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// CountEvent counts a received Discord gateway event of the given type.
+func CountEvent(eventType string) {
+	events.WithLabelValues(eventType).Inc()
+}
+
+// TrackInFlightMessage increments the number of messages being processed and returns a function decrementing it.
+func TrackInFlightMessage() (done func()) {
+	inFlightMessages.Inc()
+	return inFlightMessages.Dec
+}
+
+// SetShardStatusFunc sets the function reporting the gateway status of each shard keyed by shard ID.
+func SetShardStatusFunc(statuses func() map[int]string) {
+	shards.mu.Lock()
+	defer shards.mu.Unlock()
+	shards.statuses = statuses
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", http.NoBody))
+	body, err := io.ReadAll(w.Result().Body)
+	assert.NilError(t, err)
+	return string(body)
+}
+
+func TestHandler(t *testing.T) {
+	// The collectors are global, so start from zero when the test runs again, e.g. with -count.
+	for _, v := range []interface{ Reset() }{executions, executionDuration, outputBytes, restDuration, restErrors, events} {
+		v.Reset()
+	}
+	ObserveExecution("cat", 0, false, 10*time.Millisecond)
+	ObserveExecution("cat", -1, true, 30*time.Second)
+	ObserveOutput("cat", "stdout", 100)
+	ObserveRest("SendReply", time.Now(), errors.New("failed"))
+	CountEvent("MessageCreate")
+	done := TrackInFlightMessage()
+	SetShardStatusFunc(func() map[int]string { return map[int]string{0: "Ready", 1: "Resuming"} })
+
+	body := scrape(t)
+	assert.Assert(t, strings.Contains(body, `cli_discord_bot_executions_total{status="0",target="cat",timeout="false"} 1`))
+	assert.Assert(t, strings.Contains(body, `cli_discord_bot_executions_total{status="signaled",target="cat",timeout="true"} 1`))
+	assert.Assert(t, strings.Contains(body, `cli_discord_bot_execution_output_bytes_count{stream="stdout",target="cat"} 1`))
+	assert.Assert(t, strings.Contains(body, `cli_discord_bot_rest_errors_total{operation="SendReply"} 1`))
+	assert.Assert(t, strings.Contains(body, `cli_discord_bot_events_total{type="MessageCreate"} 1`))
+	assert.Assert(t, strings.Contains(body, `cli_discord_bot_in_flight_messages 1`))
+	assert.Assert(t, strings.Contains(body, `cli_discord_bot_shard_status{shard="0",status="Ready"} 1`))
+	assert.Assert(t, strings.Contains(body, `cli_discord_bot_shard_status{shard="1",status="Resuming"} 1`))
+
+	done()
+	assert.Assert(t, strings.Contains(scrape(t), `cli_discord_bot_in_flight_messages 0`))
+}
@@ -0,0 +1,250 @@
+// Package options provides configuration structures and utilities for the Discord bot.
+package options
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/shellwords"
+)
+
+// envDecoder populates struct fields tagged with `env` from environment variables.
+//
+// Supported field types are string, bool, integers, floats, time.Duration, encoding.TextUnmarshaler,
+// and slices and maps of those, which are split in the same way as shell words (maps take `KEY=VALUE` words).
+// Struct fields tagged with `envPrefix` are decoded recursively with the prefix prepended to their keys.
+type envDecoder struct {
+	// lookup returns the value of the environment variable and whether it exists.
+	lookup func(key string) (string, bool, error)
+	errs   []error
+}
+
+// decode decodes the environment variables into the struct pointed to by v.
+// Returns all errors found joined into a single error.
+func (d *envDecoder) decode(v any) error {
+	d.decodeStruct(reflect.ValueOf(v).Elem(), "")
+	return errors.Join(d.errs...)
+}
+
+// decodeStruct decodes the environment variables into each tagged field of the struct v.
+func (d *envDecoder) decodeStruct(v reflect.Value, prefix string) {
+	t := v.Type()
+	for i := range t.NumField() {
+		field, fieldType := v.Field(i), t.Field(i)
+		if nestedPrefix, ok := fieldType.Tag.Lookup("envPrefix"); ok && field.Kind() == reflect.Struct {
+			d.decodeStruct(field, prefix+nestedPrefix)
+			continue
+		}
+		envKey := fieldType.Tag.Get("env")
+		if envKey == "" {
+			continue
+		}
+		envKey = prefix + envKey
+		envValue, exists, err := d.lookup(envKey)
+		if err != nil {
+			d.errs = append(d.errs, err)
+			continue
+		}
+		if !exists {
+			continue
+		}
+		if err := decodeEnvValue(field, envValue); err != nil {
+			d.errs = append(d.errs, fmt.Errorf("invalid value for %s: %w", envKey, err))
+		}
+	}
+}
+
+// decodeEnvValue decodes the string s into v according to the type of v.
+func decodeEnvValue(v reflect.Value, s string) error {
+	if v.CanAddr() {
+		if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+			return u.UnmarshalText([]byte(s))
+		}
+	}
+	if v.Type() == reflect.TypeFor[time.Duration]() {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		words, err := shellwords.Split(s)
+		if err != nil {
+			return err
+		}
+		slice := reflect.MakeSlice(v.Type(), len(words), len(words))
+		for i, word := range words {
+			if err := decodeEnvValue(slice.Index(i), word); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+	case reflect.Map:
+		words, err := shellwords.Split(s)
+		if err != nil {
+			return err
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(words))
+		for _, word := range words {
+			key, value, ok := strings.Cut(word, "=")
+			if !ok {
+				return fmt.Errorf("missing `=` in %q", word)
+			}
+			k := reflect.New(v.Type().Key()).Elem()
+			if err := decodeEnvValue(k, key); err != nil {
+				return err
+			}
+			e := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeEnvValue(e, value); err != nil {
+				return err
+			}
+			m.SetMapIndex(k, e)
+		}
+		v.Set(m)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+// envKeys returns the keys of all environment variables that configure the struct type t.
+func envKeys(t reflect.Type, prefix string) []string {
+	keys := make([]string, 0, t.NumField())
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if nestedPrefix, ok := field.Tag.Lookup("envPrefix"); ok && field.Type.Kind() == reflect.Struct {
+			keys = append(keys, envKeys(field.Type, prefix+nestedPrefix)...)
+		} else if envKey := field.Tag.Get("env"); envKey != "" {
+			keys = append(keys, prefix+envKey)
+		}
+	}
+	return keys
+}
+
+// envPrefixes returns the prefixes of all nested structs of the struct type t.
+func envPrefixes(t reflect.Type, prefix string) []string {
+	prefixes := make([]string, 0)
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if nestedPrefix, ok := field.Tag.Lookup("envPrefix"); ok && field.Type.Kind() == reflect.Struct {
+			prefixes = append(prefixes, prefix+nestedPrefix)
+			prefixes = append(prefixes, envPrefixes(field.Type, prefix+nestedPrefix)...)
+		}
+	}
+	return prefixes
+}
+
+// unknownEnvProblems reports environment variables that look like misspelled configuration variables.
+// A variable starting with the prefix of a nested struct that is not a known key, e.g. `IRC_NICKK`, is an error,
+// and so is a close misspelling of a known key, e.g. `DISCORD_TOKN`: one edit away, or two edits away with the same
+// first word. Other variables within a small edit distance of a known key are more likely set by the host for
+// other purposes, and are warnings.
+func unknownEnvProblems(environ, keys, prefixes []string) (errs []error, warnings []string) {
+	const maxDistance = 2
+	const minLength = 8
+	known := make([]string, 0, len(keys)*2)
+	for _, key := range keys {
+		known = append(known, key, key+fileSuffix)
+	}
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if slices.Contains(known, name) {
+			continue
+		}
+		suggestion, distance := "", maxDistance+1
+		for _, key := range known {
+			if d := levenshtein(name, key); d < distance {
+				suggestion, distance = key, d
+			}
+		}
+		prefixed := slices.ContainsFunc(prefixes, func(p string) bool { return strings.HasPrefix(name, p) })
+		switch {
+		case len(name) >= minLength && distance <= maxDistance:
+			problem := fmt.Sprintf("unknown environment variable %s, did you mean %s?", name, suggestion)
+			if prefixed || distance == 1 || firstWord(name) == firstWord(suggestion) {
+				errs = append(errs, errors.New(problem))
+			} else {
+				warnings = append(warnings, problem)
+			}
+		case prefixed:
+			errs = append(errs, fmt.Errorf("unknown environment variable %s", name))
+		}
+	}
+	return errs, warnings
+}
+
+// firstWord returns the part of the variable name before the first underscore.
+func firstWord(name string) string {
+	word, _, _ := strings.Cut(name, "_")
+	return word
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// readEnv looks up the environment variable with lookupEnvOrFile and removes it from the environment.
+func readEnv(key string) (string, bool, error) {
+	value, exists, err := lookupEnvOrFile(key)
+	if err != nil || !exists {
+		return value, exists, err
+	}
+	// Remove the environment variable after reading it
+	if err := os.Unsetenv(key); err != nil {
+		return "", false, fmt.Errorf("failed to unset environment variable %s: %w", key, err)
+	}
+	return value, true, nil
+}
@@ -0,0 +1,129 @@
+package options
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+// --- Helper functions ---
+func lookupFromMap(env map[string]string) func(string) (string, bool, error) {
+	return func(key string) (string, bool, error) {
+		v, ok := env[key]
+		return v, ok, nil
+	}
+}
+
+// upperText is an encoding.TextUnmarshaler that stores the text in upper case.
+type upperText string
+
+func (u *upperText) UnmarshalText(text []byte) error {
+	*u = upperText(strings.ToUpper(string(text)))
+	return nil
+}
+
+type nestedConfig struct {
+	Enabled bool          `env:"ENABLED"`
+	Timeout time.Duration `env:"TIMEOUT"`
+}
+
+type decodeTarget struct {
+	Name     string            `env:"NAME"`
+	Count    int               `env:"COUNT"`
+	Port     uint16            `env:"PORT"`
+	Ratio    float64           `env:"RATIO"`
+	Timeout  time.Duration     `env:"TIMEOUT"`
+	Args     []string          `env:"ARGS"`
+	Ports    []int             `env:"PORTS"`
+	Labels   map[string]string `env:"LABELS"`
+	Text     upperText         `env:"TEXT"`
+	Nested   nestedConfig      `envPrefix:"NESTED_"`
+	Untagged string
+}
+
+func TestEnvDecoder(t *testing.T) {
+	t.Run("all types", func(t *testing.T) {
+		d := envDecoder{lookup: lookupFromMap(map[string]string{
+			"NAME":           "bot",
+			"COUNT":          "-3",
+			"PORT":           "8080",
+			"RATIO":          "0.5",
+			"TIMEOUT":        "1m30s",
+			"ARGS":           `-a "b c"`,
+			"PORTS":          "80 443",
+			"LABELS":         `a=1 "b=two words"`,
+			"TEXT":           "text",
+			"NESTED_ENABLED": "true",
+			"NESTED_TIMEOUT": "5s",
+		})}
+		var got decodeTarget
+		assert.NilError(t, d.decode(&got))
+		assert.DeepEqual(t, got, decodeTarget{
+			Name:    "bot",
+			Count:   -3,
+			Port:    8080,
+			Ratio:   0.5,
+			Timeout: 90 * time.Second,
+			Args:    []string{"-a", "b c"},
+			Ports:   []int{80, 443},
+			Labels:  map[string]string{"a": "1", "b": "two words"},
+			Text:    "TEXT",
+			Nested:  nestedConfig{Enabled: true, Timeout: 5 * time.Second},
+		})
+	})
+
+	t.Run("reports all errors", func(t *testing.T) {
+		d := envDecoder{lookup: lookupFromMap(map[string]string{
+			"COUNT":          "three",
+			"PORT":           "70000",
+			"LABELS":         "novalue",
+			"NESTED_ENABLED": "maybe",
+		})}
+		var got decodeTarget
+		err := d.decode(&got)
+		assert.ErrorContains(t, err, "invalid value for COUNT")
+		assert.ErrorContains(t, err, "invalid value for PORT")
+		assert.ErrorContains(t, err, "invalid value for LABELS")
+		assert.ErrorContains(t, err, "invalid value for NESTED_ENABLED")
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		var got struct {
+			C chan int `env:"C"`
+		}
+		d := envDecoder{lookup: lookupFromMap(map[string]string{"C": "1"})}
+		assert.ErrorContains(t, d.decode(&got), "unsupported type chan int")
+	})
+}
+
+func TestUnknownEnvProblems(t *testing.T) {
+	typ := reflect.TypeFor[decodeTarget]()
+	errs, warnings := unknownEnvProblems(
+		[]string{
+			"TIMEOUTS=1", "NESTED_ENABLE=1", "NESTED_TIMEOUT=1s", "NESTED_SERVICE_HOST=10.0.0.1",
+			"PATH=/bin", "HOME=/root", "NAME_FILE=/x", "TIMEOUTS_FILE=/x", "NESTED_SECRET_FILE=/x", "COUNTRY_FILE=/x",
+		},
+		envKeys(typ, ""),
+		envPrefixes(typ, ""),
+	)
+	// Variables like known keys with another first word are likely set by the host for other purposes.
+	assert.DeepEqual(t, warnings, []string{
+		"unknown environment variable COUNTRY_FILE, did you mean COUNT_FILE?",
+	})
+	assert.Equal(t, len(errs), 5)
+	assert.Error(t, errs[0], "unknown environment variable TIMEOUTS, did you mean TIMEOUT?")
+	assert.Error(t, errs[1], "unknown environment variable NESTED_ENABLE, did you mean NESTED_ENABLED?")
+	assert.Error(t, errs[2], "unknown environment variable NESTED_SERVICE_HOST")
+	assert.Error(t, errs[3], "unknown environment variable TIMEOUTS_FILE, did you mean TIMEOUT_FILE?")
+	assert.Error(t, errs[4], "unknown environment variable NESTED_SECRET_FILE")
+}
+
+func TestLevenshtein(t *testing.T) {
+	assert.Equal(t, levenshtein("", ""), 0)
+	assert.Equal(t, levenshtein("abc", ""), 3)
+	assert.Equal(t, levenshtein("kitten", "sitting"), 3)
+	assert.Equal(t, levenshtein("TIMEOUT_SECOND", "TIMEOUT_SECONDS"), 1)
+}
@@ -6,98 +6,129 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"log/slog"
 	"os"
 	"reflect"
+	"runtime"
 	"slices"
-	"strconv"
 	"strings"
 	"syscall"
+	"text/template"
 	"time"
-
-	"github.com/norio-nomura/cli_discord_bot2/pkg/shellwords"
 )
 
+// fileSuffix is appended to an environment variable name to read its value from a file.
+const fileSuffix = "_FILE"
+
 // Options holds configuration values for the Discord bot, loaded from environment variables or JSON.
 type Options struct {
-	AttachmentExtensionToTreatAsInput  string   `env:"ATTACHMENT_EXTENSION_TO_TREAT_AS_INPUT" json:","`
-	DiscordNickname                    string   `env:"DISCORD_NICKNAME" json:",omitempty"`
-	DiscordPlaying                     string   `env:"DISCORD_PLAYING" json:",omitempty"`
-	DiscordToken                       string   `env:"DISCORD_TOKEN" json:","`
-	EnvCommand                         []string `env:"ENV_COMMAND" json:","`
-	NumberOfLinesToEmbedOutput         int      `env:"NUMBER_OF_LINES_TO_EMBED_OUTPUT" json:","`
-	NumberOfLinesToEmbedUploadedOutput int      `env:"NUMBER_OF_LINES_TO_EMBED_UPLOADED_OUTPUT" json:","`
-	RestTimeoutSeconds                 int      `env:"REST_TIMEOUT_SECONDS" json:","`
-	TargetArgsToUseStdin               []string `env:"TARGET_ARGS_TO_USE_STDIN" json:","`
-	TargetCLI                          string   `env:"TARGET_CLI" json:","`
-	TargetDefaultArgs                  []string `env:"TARGET_DEFAULT_ARGS" json:","`
-	TimeoutSeconds                     int      `env:"TIMEOUT_SECONDS" json:","`
+	AttachmentExtensionToTreatAsInput  string     `env:"ATTACHMENT_EXTENSION_TO_TREAT_AS_INPUT" json:","`
+	CABundlePath                       string     `env:"CA_BUNDLE_PATH" json:","`
+	DirectiveEnvNames                  []string   `env:"DIRECTIVE_ENV_NAMES" json:","`
+	DiscordAPIURL                      string     `env:"DISCORD_API_URL" json:","`
+	DiscordGatewayURL                  string     `env:"DISCORD_GATEWAY_URL" json:","`
+	DiscordNickname                    string     `env:"DISCORD_NICKNAME" json:",omitempty"`
+	DiscordPlaying                     string     `env:"DISCORD_PLAYING" json:",omitempty"`
+	DiscordToken                       string     `env:"DISCORD_TOKEN" json:","`
+	DrainTimeoutSeconds                int        `env:"DRAIN_TIMEOUT_SECONDS" json:","`
+	EnvCommand                         []string   `env:"ENV_COMMAND" json:","`
+	EnvPassthrough                     []string   `env:"ENV_PASSTHROUGH" json:","`
+	EnvVars                            []string   `env:"ENV_VARS" json:","`
+	EnvWorkspaceDirs                   []string   `env:"ENV_WORKSPACE_DIRS" json:","`
+	ExecutionQueueSize                 int        `env:"EXECUTION_QUEUE_SIZE" json:","`
+	HTTPAPIToken                       string     `env:"HTTP_API_TOKEN" json:","`
+	HTTPListenAddress                  string     `env:"HTTP_LISTEN_ADDRESS" json:","`
+	HTTPProxyURL                       string     `env:"HTTP_PROXY_URL" json:","`
+	IRC                                IRCOptions `envPrefix:"IRC_" json:","`
+	LogFormat                          string     `env:"LOG_FORMAT" json:","`
+	LogLevel                           slog.Level `env:"LOG_LEVEL" json:","`
+	MaxConcurrentExecutions            int        `env:"MAX_CONCURRENT_EXECUTIONS" json:","`
+	MaxTimeoutSeconds                  int        `env:"MAX_TIMEOUT_SECONDS" json:","`
+	NicknameCheckIntervalSeconds       int        `env:"NICKNAME_CHECK_INTERVAL_SECONDS" json:","`
+	NumberOfLinesToEmbedOutput         int        `env:"NUMBER_OF_LINES_TO_EMBED_OUTPUT" json:","`
+	NumberOfLinesToEmbedUploadedOutput int        `env:"NUMBER_OF_LINES_TO_EMBED_UPLOADED_OUTPUT" json:","`
+	PresenceIntervalSeconds            int        `env:"PRESENCE_INTERVAL_SECONDS" json:","`
+	ReconcileWindowSeconds             int        `env:"RECONCILE_WINDOW_SECONDS" json:","`
+	ReplyStoreCapacity                 int        `env:"REPLY_STORE_CAPACITY" json:","`
+	ReplyStorePath                     string     `env:"REPLY_STORE_PATH" json:","`
+	RestTimeoutSeconds                 int        `env:"REST_TIMEOUT_SECONDS" json:","`
+	ShardCount                         int        `env:"SHARD_COUNT" json:","`
+	ShardIDs                           []int      `env:"SHARD_IDS" json:","`
+	ShardingEnabled                    bool       `env:"SHARDING_ENABLED" json:","`
+	StrictEnv                          bool       `env:"STRICT_ENV" json:","`
+	TargetArgsToUseStdin               []string   `env:"TARGET_ARGS_TO_USE_STDIN" json:","`
+	TargetCLI                          string     `env:"TARGET_CLI" json:","`
+	TargetDefaultArgs                  []string   `env:"TARGET_DEFAULT_ARGS" json:","`
+	TimeoutSeconds                     int        `env:"TIMEOUT_SECONDS" json:","`
+	VersionCommand                     []string   `env:"VERSION_COMMAND" json:","`
+}
+
+// IRCOptions holds the configuration of the IRC frontend, loaded from variables prefixed with `IRC_`.
+// The frontend is enabled if Server is set.
+type IRCOptions struct {
+	Channels         []string `env:"CHANNELS" json:","`
+	MaxLines         int      `env:"MAX_LINES" json:","`
+	Nick             string   `env:"NICK" json:","`
+	PasteURLPrefixes []string `env:"PASTE_URL_PREFIXES" json:","`
+	SASLPassword     string   `env:"SASL_PASSWORD" json:","`
+	SASLUsername     string   `env:"SASL_USERNAME" json:","`
+	Server           string   `env:"SERVER" json:","`
+	TLS              bool     `env:"TLS" json:","`
+	UploadURL        string   `env:"UPLOAD_URL" json:","`
 }
 
 // defaultOptions creates a new Options instance with default values.
 func defaultOptions() *Options {
 	return &Options{
+		DrainTimeoutSeconds:                30,
 		EnvCommand:                         []string{"/usr/bin/env", "-i"},
+		EnvWorkspaceDirs:                   []string{"HOME", "TMPDIR"},
+		ExecutionQueueSize:                 100,
+		IRC:                                IRCOptions{MaxLines: 5},
+		NicknameCheckIntervalSeconds:       60 * 60,
 		NumberOfLinesToEmbedOutput:         20,
 		NumberOfLinesToEmbedUploadedOutput: 3,
+		PresenceIntervalSeconds:            60,
+		ReconcileWindowSeconds:             24 * 60 * 60,
+		ReplyStoreCapacity:                 10000,
 		RestTimeoutSeconds:                 10,
+		StrictEnv:                          true,
 		TargetCLI:                          "cat",
 		TimeoutSeconds:                     30,
 	}
 }
 
-// FromEnv populates Options from environment variables dynamically.
-// Returns an Options pointer or an error if required fields are missing or invalid.
+// FromEnv populates Options from environment variables dynamically using envDecoder.
+// Returns an Options pointer and an error listing every variable that is missing or invalid.
+// The Options are returned even on error so that callers can report further problems.
 func FromEnv() (*Options, error) {
-	options := defaultOptions()
-	v := reflect.ValueOf(options).Elem()
-	t := v.Type()
-
-	for i := range v.NumField() {
-		field := v.Field(i)
-		fieldType := t.Field(i)
-
-		envKey := fieldType.Tag.Get("env")
-		if envKey == "" {
-			continue
-		}
-
-		envValue, exists := os.LookupEnv(envKey)
-		if !exists {
-			continue
-		}
+	return fromEnv(true)
+}
 
-		// Set the field value based on its type
-		switch field.Kind() {
-		case reflect.Slice:
-			if field.Type().Elem().Kind() == reflect.String {
-				// Split the string by spaces to create a slice of strings
-				sliceValue, err := shellwords.Split(envValue)
-				if err != nil {
-					return nil, fmt.Errorf("failed to parse %s: %w", envKey, err)
-				}
-				field.Set(reflect.ValueOf(sliceValue))
-			} else {
-				return nil, fmt.Errorf("unsupported slice type for %s", envKey)
-			}
-		case reflect.String:
-			field.SetString(envValue)
-		case reflect.Int:
-			intValue, err := strconv.Atoi(envValue)
-			if err != nil {
-				return nil, fmt.Errorf("invalid value for %s: %w", envKey, err)
-			}
-			field.SetInt(int64(intValue))
-		}
+// FromEnvWithoutToken populates Options like FromEnv, but does not require `DISCORD_TOKEN`.
+// It is used by the `--local` mode, which never connects to Discord.
+func FromEnvWithoutToken() (*Options, error) {
+	return fromEnv(false)
+}
 
-		// Remove the environment variable after reading it
-		if err := os.Unsetenv(envKey); err != nil {
-			return nil, fmt.Errorf("failed to unset environment variable %s: %w", envKey, err)
+// fromEnv populates Options from environment variables, requiring `DISCORD_TOKEN` if requireToken is true.
+func fromEnv(requireToken bool) (*Options, error) {
+	options := defaultOptions()
+	// Report variables that look like typos before decoding removes the known ones.
+	t := reflect.TypeFor[Options]()
+	problems, warnings := unknownEnvProblems(os.Environ(), envKeys(t, ""), envPrefixes(t, ""))
+	decoder := envDecoder{lookup: readEnv}
+	errs := []error{decoder.decode(options)}
+	if options.StrictEnv {
+		errs = append(errs, problems...)
+	} else {
+		// Hosts may set such variables for other purposes, e.g. Kubernetes sets `IRC_PORT` for a service named irc.
+		for _, problem := range problems {
+			warnings = append(warnings, problem.Error())
 		}
 	}
-
-	// Ensure required fields are set
-	if options.DiscordToken == "" {
-		return nil, errors.New("`DISCORD_TOKEN` is missing in environment variables")
+	for _, warning := range warnings {
+		slog.Warn(warning)
 	}
 
 	// pass PATH="..." to EnvCommand if not set
@@ -105,11 +136,50 @@ func FromEnv() (*Options, error) {
 		options.EnvCommand = append(options.EnvCommand, "PATH="+os.Getenv("PATH"))
 	}
 
-	return options, nil
+	// Ensure all fields are valid
+	return options, errors.Join(append(errs, options.validate(requireToken))...)
+}
+
+// lookupEnvOrFile looks up the environment variable named by key.
+// If `<key>_FILE` is set instead, the value is read from that file with surrounding whitespace trimmed,
+// which allows passing secrets via Docker or Kubernetes secret files.
+// The `<key>_FILE` variable is removed from the environment after reading it.
+func lookupEnvOrFile(key string) (string, bool, error) {
+	value, exists := os.LookupEnv(key)
+	fileKey := key + fileSuffix
+	path, fileExists := os.LookupEnv(fileKey)
+	if !fileExists {
+		return value, exists, nil
+	}
+	if err := os.Unsetenv(fileKey); err != nil {
+		return "", false, fmt.Errorf("failed to unset environment variable %s: %w", fileKey, err)
+	}
+	if exists {
+		return "", false, fmt.Errorf("both %s and %s are set", key, fileKey)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read %s: %w", fileKey, err)
+	}
+	return strings.TrimSpace(string(data)), true, nil
+}
+
+// Environ returns a copy of the current environment without any variable that configures Options.
+// It is used for the re-executed process and target CLI children so that secrets never leak to them.
+func Environ() []string {
+	keys := make([]string, 0)
+	for _, envKey := range envKeys(reflect.TypeFor[Options](), "") {
+		keys = append(keys, envKey, envKey+fileSuffix)
+	}
+	return slices.DeleteFunc(os.Environ(), func(kv string) bool {
+		key, _, _ := strings.Cut(kv, "=")
+		return slices.Contains(keys, key)
+	})
 }
 
 // FromStdin reads JSON from standard input and populates Options.
-// Returns an Options pointer or an error if required fields are missing or invalid.
+// Returns an Options pointer and an error if the JSON is malformed or any field is invalid.
+// The Options are returned even if validation fails so that callers can report further problems.
 func FromStdin() (*Options, error) {
 	options := defaultOptions()
 	decoder := json.NewDecoder(os.Stdin)
@@ -117,11 +187,8 @@ func FromStdin() (*Options, error) {
 		return nil, fmt.Errorf("failed to decode JSON: %w", err)
 	}
 
-	// Ensure required fields are set
-	if options.DiscordToken == "" {
-		return nil, errors.New("`DISCORD_TOKEN` is missing in JSON")
-	}
-	return options, nil
+	// Ensure all fields are valid
+	return options, options.Validate()
 }
 
 // Discord returns the Discord nickname and playing status from the options.
@@ -140,6 +207,35 @@ func (o *Options) Discord() (nickname, playing string) {
 	return
 }
 
+// IRCNick returns the IRC nickname, falling back to the TargetCLI value.
+func (o *Options) IRCNick() string {
+	if o.IRC.Nick != "" {
+		return o.IRC.Nick
+	}
+	return o.TargetCLI
+}
+
+// TargetEnv returns the `KEY=VALUE` assignments passed to EnvCommand before the target CLI.
+// It forwards the host variables listed in EnvPassthrough that are set, followed by EnvVars.
+// Configuration variables such as `DISCORD_TOKEN` are never forwarded.
+func (o *Options) TargetEnv() []string {
+	env := Environ()
+	assignments := make([]string, 0, len(o.EnvPassthrough)+len(o.EnvVars))
+	for _, name := range o.EnvPassthrough {
+		i := slices.IndexFunc(env, func(kv string) bool { return strings.HasPrefix(kv, name+"=") })
+		if i >= 0 {
+			assignments = append(assignments, env[i])
+		}
+	}
+	return append(assignments, o.EnvVars...)
+}
+
+// Sharding returns true if the bot should connect through a shard manager instead of a single gateway.
+// Sharding is enabled by ShardingEnabled, or implicitly by setting ShardCount or ShardIDs.
+func (o *Options) Sharding() bool {
+	return o.ShardingEnabled || o.ShardCount > 0 || len(o.ShardIDs) > 0
+}
+
 // ExecWithPassingOptionsToStdin serializes the Options to JSON, sets up a pipe, and replaces the current process.
 // This method can be used to re-execute the current process with options passed via stdin.
 func (o *Options) ExecWithPassingOptionsToStdin() error {
@@ -157,7 +253,7 @@ func (o *Options) ExecWithPassingOptionsToStdin() error {
 
 	cmd := os.Args[0]
 	args := slices.Insert(os.Args[1:], 0, "--stdin")
-	env := os.Environ()
+	env := Environ()
 
 	// Create a pipe for stdin
 	r, w, err := os.Pipe()
@@ -193,12 +289,56 @@ func (o *Options) ContextWithRestTimeout(ctx context.Context) (context.Context,
 	return context.WithTimeout(ctx, time.Duration(timeout)*time.Second)
 }
 
+// DrainTimeout returns the duration to wait for in-flight jobs on shutdown before aborting them.
+func (o *Options) DrainTimeout() time.Duration {
+	return time.Duration(o.DrainTimeoutSeconds) * time.Second
+}
+
+// ParsePresenceTemplate parses the `DISCORD_PLAYING` status as a text/template.
+func ParsePresenceTemplate(text string) (*template.Template, error) {
+	return template.New("DISCORD_PLAYING").Option("missingkey=error").Parse(text)
+}
+
+// ConcurrentExecutions returns the number of target CLI processes that may run at once,
+// which defaults to the number of CPUs.
+func (o *Options) ConcurrentExecutions() int {
+	if o.MaxConcurrentExecutions > 0 {
+		return o.MaxConcurrentExecutions
+	}
+	return runtime.NumCPU()
+}
+
+// PresenceInterval returns the interval between presence refreshes, or 0 if disabled.
+func (o *Options) PresenceInterval() time.Duration {
+	return time.Duration(o.PresenceIntervalSeconds) * time.Second
+}
+
+// NicknameCheckInterval returns the interval between nickname verifications, or 0 if disabled.
+func (o *Options) NicknameCheckInterval() time.Duration {
+	return time.Duration(o.NicknameCheckIntervalSeconds) * time.Second
+}
+
+// ReconcileWindow returns how far back answered messages are reconciled on startup, or 0 if disabled.
+func (o *Options) ReconcileWindow() time.Duration {
+	return time.Duration(o.ReconcileWindowSeconds) * time.Second
+}
+
 // ContextWithTimeout creates a context with the timeout duration.
 // This context can be used to enforce a timeout for operations that may take too long.
 func (o *Options) ContextWithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return o.ContextWithTimeoutSeconds(ctx, 0)
+}
+
+// ContextWithTimeoutSeconds creates a context with the timeout requested by a `timeout=` directive.
+// The requested seconds are bounded by MaxTimeoutSeconds, and the default timeout is used if it is not positive
+// or if MaxTimeoutSeconds is not set.
+func (o *Options) ContextWithTimeoutSeconds(ctx context.Context, seconds int) (context.Context, context.CancelFunc) {
 	timeout := o.TimeoutSeconds
 	if timeout <= 0 {
 		timeout = defaultOptions().TimeoutSeconds
 	}
+	if seconds > 0 && o.MaxTimeoutSeconds > 0 {
+		timeout = min(seconds, o.MaxTimeoutSeconds)
+	}
 	return context.WithTimeoutCause(ctx, time.Duration(timeout)*time.Second, fmt.Errorf("process killed due to timeout of %d seconds", timeout))
 }
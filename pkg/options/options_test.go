@@ -0,0 +1,105 @@
+package options
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"gotest.tools/v3/assert"
+)
+
+func writeSecret(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "secret")
+	assert.NilError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestFromEnv_File(t *testing.T) {
+	t.Run("reads and trims value", func(t *testing.T) {
+		t.Setenv("DISCORD_TOKEN_FILE", writeSecret(t, "  token-from-file\n"))
+		t.Setenv("TIMEOUT_SECONDS_FILE", writeSecret(t, "42\n"))
+		o, err := FromEnv()
+		assert.NilError(t, err)
+		assert.Equal(t, o.DiscordToken, "token-from-file")
+		assert.Equal(t, o.TimeoutSeconds, 42)
+		_, exists := os.LookupEnv("DISCORD_TOKEN_FILE")
+		assert.Assert(t, !exists)
+	})
+
+	t.Run("both set", func(t *testing.T) {
+		t.Setenv("DISCORD_TOKEN", "token")
+		t.Setenv("DISCORD_TOKEN_FILE", writeSecret(t, "token"))
+		_, err := FromEnv()
+		assert.ErrorContains(t, err, "both DISCORD_TOKEN and DISCORD_TOKEN_FILE are set")
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		t.Setenv("DISCORD_TOKEN_FILE", filepath.Join(t.TempDir(), "missing"))
+		_, err := FromEnv()
+		assert.ErrorContains(t, err, "failed to read DISCORD_TOKEN_FILE")
+	})
+}
+
+func TestFromEnv_StrictEnv(t *testing.T) {
+	t.Setenv("DISCORD_TOKEN", "token")
+	t.Setenv("IRC_NICKK", "cat")
+	_, err := FromEnv()
+	assert.ErrorContains(t, err, "unknown environment variable IRC_NICKK, did you mean IRC_NICK?")
+
+	// The known variables are removed from the environment after reading.
+	t.Setenv("DISCORD_TOKEN", "token")
+	t.Setenv("STRICT_ENV", "false")
+	_, err = FromEnv()
+	assert.NilError(t, err)
+}
+
+func TestFromEnvWithoutToken(t *testing.T) {
+	t.Setenv("DISCORD_TOKEN", "")
+	_, err := FromEnv()
+	assert.ErrorContains(t, err, "`DISCORD_TOKEN` is missing")
+
+	t.Setenv("DISCORD_TOKEN", "")
+	t.Setenv("TIMEOUT_SECONDS", "5")
+	o, err := FromEnvWithoutToken()
+	assert.NilError(t, err)
+	assert.Equal(t, o.TimeoutSeconds, 5)
+}
+
+func TestEnviron(t *testing.T) {
+	t.Setenv("DISCORD_TOKEN", "token")
+	t.Setenv("DISCORD_TOKEN_FILE", "/run/secrets/token")
+	t.Setenv("CLI_DISCORD_BOT2_TEST", "kept")
+	env := Environ()
+	assert.Assert(t, !slices.ContainsFunc(env, func(kv string) bool { return strings.HasPrefix(kv, "DISCORD_TOKEN") }))
+	assert.Assert(t, slices.Contains(env, "CLI_DISCORD_BOT2_TEST=kept"))
+}
+
+func TestTargetEnv(t *testing.T) {
+	t.Setenv("LANG", "C.UTF-8")
+	t.Setenv("DISCORD_TOKEN", "token")
+	o := &Options{
+		EnvPassthrough: []string{"LANG", "DISCORD_TOKEN", "CLI_DISCORD_BOT2_UNSET"},
+		EnvVars:        []string{"RUST_BACKTRACE=1"},
+	}
+	assert.DeepEqual(t, o.TargetEnv(), []string{"LANG=C.UTF-8", "RUST_BACKTRACE=1"})
+}
+
+func TestContextWithTimeoutSeconds(t *testing.T) {
+	deadlineAfter := func(o *Options, seconds int) time.Duration {
+		ctx, cancel := o.ContextWithTimeoutSeconds(context.Background(), seconds)
+		defer cancel()
+		deadline, ok := ctx.Deadline()
+		assert.Assert(t, ok)
+		return time.Until(deadline).Round(time.Second)
+	}
+	o := &Options{TimeoutSeconds: 30, MaxTimeoutSeconds: 60}
+	assert.Equal(t, deadlineAfter(o, 0), 30*time.Second)
+	assert.Equal(t, deadlineAfter(o, 45), 45*time.Second)
+	assert.Equal(t, deadlineAfter(o, 600), 60*time.Second)
+	assert.Equal(t, deadlineAfter(&Options{TimeoutSeconds: 30}, 45), 30*time.Second)
+}
@@ -0,0 +1,51 @@
+package options
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/url"
+	"os"
+)
+
+// ProxyURL returns the parsed HTTPProxyURL, or nil if it is not set.
+func (o *Options) ProxyURL() (*url.URL, error) {
+	if o.HTTPProxyURL == "" {
+		return nil, nil
+	}
+	return parseURL(o.HTTPProxyURL, "http", "https", "socks5")
+}
+
+// TLSConfig returns a TLS configuration trusting the certificates in CABundlePath in addition to
+// the system roots, or nil if CABundlePath is not set.
+func (o *Options) TLSConfig() (*tls.Config, error) {
+	if o.CABundlePath == "" {
+		return nil, nil
+	}
+	pem, err := os.ReadFile(o.CABundlePath)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", o.CABundlePath)
+	}
+	return &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}, nil
+}
+
+// parseURL parses rawURL as an absolute URL with one of the schemes.
+func parseURL(rawURL string, schemes ...string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme && u.Host != "" {
+			return u, nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not an absolute URL with scheme %v", u.Redacted(), schemes)
+}
@@ -0,0 +1,36 @@
+package options
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestTLSConfig(t *testing.T) {
+	s := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {}))
+	defer s.Close()
+
+	o := &Options{}
+	tlsConfig, err := o.TLSConfig()
+	assert.NilError(t, err)
+	assert.Assert(t, tlsConfig == nil)
+
+	o.CABundlePath = filepath.Join(t.TempDir(), "ca.pem")
+	assert.NilError(t, os.WriteFile(o.CABundlePath, []byte("not a certificate"), 0o600))
+	_, err = o.TLSConfig()
+	assert.ErrorContains(t, err, "no certificates found")
+
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: s.Certificate().Raw})
+	assert.NilError(t, os.WriteFile(o.CABundlePath, cert, 0o600))
+	tlsConfig, err = o.TLSConfig()
+	assert.NilError(t, err)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+	rs, err := client.Get(s.URL)
+	assert.NilError(t, err)
+	assert.NilError(t, rs.Body.Close())
+}
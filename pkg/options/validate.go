@@ -0,0 +1,273 @@
+// Package options provides configuration structures and utilities for the Discord bot.
+package options
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/norio-nomura/cli_discord_bot2/pkg/logging"
+)
+
+// envNamePattern matches valid environment variable names.
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// minPresenceIntervalSeconds keeps presence refreshes well below the gateway rate limit of presence updates.
+const minPresenceIntervalSeconds = 15
+
+// redacted is the placeholder used in place of secrets when printing the configuration.
+const redacted = "<redacted>"
+
+// Validate checks every field of Options for semantically invalid values.
+// It returns all problems found joined into a single error, or nil if the options are valid.
+func (o *Options) Validate() error {
+	return o.validate(true)
+}
+
+// validate checks every field of Options, and requires DiscordToken if requireToken is true.
+func (o *Options) validate(requireToken bool) error {
+	var errs []error
+	if requireToken && o.DiscordToken == "" {
+		errs = append(errs, errors.New("`DISCORD_TOKEN` is missing"))
+	}
+	if o.DiscordAPIURL != "" {
+		if _, err := parseURL(o.DiscordAPIURL, "http", "https"); err != nil {
+			errs = append(errs, fmt.Errorf("`DISCORD_API_URL` is invalid: %w", err))
+		}
+	}
+	if o.DiscordGatewayURL != "" {
+		if _, err := parseURL(o.DiscordGatewayURL, "ws", "wss"); err != nil {
+			errs = append(errs, fmt.Errorf("`DISCORD_GATEWAY_URL` is invalid: %w", err))
+		}
+	}
+	if _, err := o.ProxyURL(); err != nil {
+		errs = append(errs, fmt.Errorf("`HTTP_PROXY_URL` is invalid: %w", err))
+	}
+	if _, err := o.TLSConfig(); err != nil {
+		errs = append(errs, fmt.Errorf("`CA_BUNDLE_PATH` is invalid: %w", err))
+	}
+	if o.DrainTimeoutSeconds < 0 {
+		errs = append(errs, fmt.Errorf("`DRAIN_TIMEOUT_SECONDS` must not be negative: %d", o.DrainTimeoutSeconds))
+	}
+	if len(o.EnvCommand) == 0 || o.EnvCommand[0] == "" {
+		errs = append(errs, errors.New("`ENV_COMMAND` must not be empty"))
+	}
+	for _, name := range o.EnvPassthrough {
+		if !isEnvName(name) {
+			errs = append(errs, fmt.Errorf("`ENV_PASSTHROUGH` contains an invalid variable name: %q", name))
+		}
+	}
+	for _, kv := range o.EnvVars {
+		if name, _, ok := strings.Cut(kv, "="); !ok || !isEnvName(name) {
+			errs = append(errs, fmt.Errorf("`ENV_VARS` must contain `NAME=VALUE` pairs: %q", kv))
+		}
+	}
+	for i, name := range o.EnvWorkspaceDirs {
+		if !isEnvName(name) {
+			errs = append(errs, fmt.Errorf("`ENV_WORKSPACE_DIRS` contains an invalid variable name: %q", name))
+		}
+		// The directories are named after the variables, which must not collide on case-insensitive file systems.
+		if slices.ContainsFunc(o.EnvWorkspaceDirs[:i], func(s string) bool { return strings.EqualFold(s, name) }) {
+			errs = append(errs, fmt.Errorf("`ENV_WORKSPACE_DIRS` contains a duplicate variable name: %q", name))
+		}
+	}
+	if o.TargetCLI == "" {
+		errs = append(errs, errors.New("`TARGET_CLI` must not be empty"))
+	}
+	if o.NumberOfLinesToEmbedOutput < 0 {
+		errs = append(errs, fmt.Errorf("`NUMBER_OF_LINES_TO_EMBED_OUTPUT` must not be negative: %d", o.NumberOfLinesToEmbedOutput))
+	}
+	if o.NumberOfLinesToEmbedUploadedOutput < 0 {
+		errs = append(errs, fmt.Errorf("`NUMBER_OF_LINES_TO_EMBED_UPLOADED_OUTPUT` must not be negative: %d", o.NumberOfLinesToEmbedUploadedOutput))
+	}
+	if o.NicknameCheckIntervalSeconds < 0 {
+		errs = append(errs, fmt.Errorf("`NICKNAME_CHECK_INTERVAL_SECONDS` must not be negative: %d", o.NicknameCheckIntervalSeconds))
+	}
+	if o.PresenceIntervalSeconds != 0 && o.PresenceIntervalSeconds < minPresenceIntervalSeconds {
+		errs = append(errs, fmt.Errorf("`PRESENCE_INTERVAL_SECONDS` must be 0 or at least %d: %d", minPresenceIntervalSeconds, o.PresenceIntervalSeconds))
+	}
+	if _, playing := o.Discord(); playing != "" {
+		if _, err := ParsePresenceTemplate(playing); err != nil {
+			errs = append(errs, fmt.Errorf("`DISCORD_PLAYING` is not a valid template: %w", err))
+		}
+	}
+	if o.HTTPAPIToken != "" && o.HTTPListenAddress == "" {
+		errs = append(errs, errors.New("`HTTP_API_TOKEN` requires `HTTP_LISTEN_ADDRESS`"))
+	}
+	errs = append(errs, o.validateIRC()...)
+	if o.MaxConcurrentExecutions < 0 {
+		errs = append(errs, fmt.Errorf("`MAX_CONCURRENT_EXECUTIONS` must not be negative: %d", o.MaxConcurrentExecutions))
+	}
+	if o.ExecutionQueueSize < 0 {
+		errs = append(errs, fmt.Errorf("`EXECUTION_QUEUE_SIZE` must not be negative: %d", o.ExecutionQueueSize))
+	}
+	if o.ReconcileWindowSeconds < 0 {
+		errs = append(errs, fmt.Errorf("`RECONCILE_WINDOW_SECONDS` must not be negative: %d", o.ReconcileWindowSeconds))
+	}
+	if o.ReplyStoreCapacity < 0 {
+		errs = append(errs, fmt.Errorf("`REPLY_STORE_CAPACITY` must not be negative: %d", o.ReplyStoreCapacity))
+	}
+	if o.RestTimeoutSeconds < 0 {
+		errs = append(errs, fmt.Errorf("`REST_TIMEOUT_SECONDS` must not be negative: %d", o.RestTimeoutSeconds))
+	}
+	if _, err := logging.New(io.Discard, o.LogLevel, o.LogFormat); err != nil {
+		errs = append(errs, fmt.Errorf("`LOG_FORMAT` is invalid: %w", err))
+	}
+	if o.MaxTimeoutSeconds < 0 {
+		errs = append(errs, fmt.Errorf("`MAX_TIMEOUT_SECONDS` must not be negative: %d", o.MaxTimeoutSeconds))
+	} else if timeout := o.TimeoutSeconds; o.MaxTimeoutSeconds > 0 {
+		if timeout <= 0 {
+			timeout = defaultOptions().TimeoutSeconds
+		}
+		if o.MaxTimeoutSeconds < timeout {
+			errs = append(errs, fmt.Errorf("`MAX_TIMEOUT_SECONDS` must not be less than `TIMEOUT_SECONDS` (%d): %d", timeout, o.MaxTimeoutSeconds))
+		}
+	}
+	for _, name := range o.DirectiveEnvNames {
+		if !isEnvName(name) {
+			errs = append(errs, fmt.Errorf("`DIRECTIVE_ENV_NAMES` contains an invalid variable name: %q", name))
+		}
+	}
+	if o.ShardCount < 0 {
+		errs = append(errs, fmt.Errorf("`SHARD_COUNT` must not be negative: %d", o.ShardCount))
+	}
+	if len(o.ShardIDs) > 0 && o.ShardCount == 0 {
+		errs = append(errs, errors.New("`SHARD_IDS` requires `SHARD_COUNT`"))
+	}
+	for _, id := range o.ShardIDs {
+		if id < 0 || (o.ShardCount > 0 && id >= o.ShardCount) {
+			errs = append(errs, fmt.Errorf("`SHARD_IDS` contains an invalid shard ID: %d", id))
+		}
+	}
+	if o.TimeoutSeconds < 0 {
+		errs = append(errs, fmt.Errorf("`TIMEOUT_SECONDS` must not be negative: %d", o.TimeoutSeconds))
+	}
+	return errors.Join(errs...)
+}
+
+// validateIRC checks the options of the IRC frontend if it is enabled.
+func (o *Options) validateIRC() []error {
+	if o.IRC.Server == "" {
+		return nil
+	}
+	var errs []error
+	if _, _, err := net.SplitHostPort(o.IRC.Server); err != nil {
+		errs = append(errs, fmt.Errorf("`IRC_SERVER` must be `HOST:PORT`: %w", err))
+	}
+	if nick := o.IRCNick(); nick == "" || strings.ContainsAny(nick, " ,*?!@:#&") {
+		errs = append(errs, fmt.Errorf("`IRC_NICK` is not a valid nickname: %q", nick))
+	}
+	for _, channel := range o.IRC.Channels {
+		if !strings.HasPrefix(channel, "#") && !strings.HasPrefix(channel, "&") || strings.ContainsAny(channel, " ,\x07") {
+			errs = append(errs, fmt.Errorf("`IRC_CHANNELS` contains an invalid channel: %q", channel))
+		}
+	}
+	if o.IRC.MaxLines < 1 {
+		errs = append(errs, fmt.Errorf("`IRC_MAX_LINES` must be positive: %d", o.IRC.MaxLines))
+	}
+	if (o.IRC.SASLUsername == "") != (o.IRC.SASLPassword == "") {
+		errs = append(errs, errors.New("`IRC_SASL_USERNAME` and `IRC_SASL_PASSWORD` must be set together"))
+	}
+	for _, prefix := range o.IRC.PasteURLPrefixes {
+		if _, err := parseURL(prefix, "http", "https"); err != nil {
+			errs = append(errs, fmt.Errorf("`IRC_PASTE_URL_PREFIXES` is invalid: %w", err))
+		}
+	}
+	if o.IRC.UploadURL != "" {
+		if _, err := parseURL(o.IRC.UploadURL, "http", "https"); err != nil {
+			errs = append(errs, fmt.Errorf("`IRC_UPLOAD_URL` is invalid: %w", err))
+		}
+	}
+	return errs
+}
+
+// CheckCommands verifies that the commands can be resolved on this host.
+// It looks up EnvCommand in the current PATH and TargetCLI in the PATH passed to EnvCommand.
+func (o *Options) CheckCommands() error {
+	var errs []error
+	if len(o.EnvCommand) > 0 && o.EnvCommand[0] != "" {
+		if _, err := exec.LookPath(o.EnvCommand[0]); err != nil {
+			errs = append(errs, fmt.Errorf("`ENV_COMMAND` is not executable: %w", err))
+		}
+	}
+	if o.TargetCLI != "" {
+		if _, err := o.LookPathTargetCLI(); err != nil {
+			errs = append(errs, fmt.Errorf("`TARGET_CLI` is not executable: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// LookPathTargetCLI resolves TargetCLI in the same way EnvCommand will when launching it.
+// The PATH is taken from the last `PATH=` argument of EnvCommand, falling back to the current PATH.
+func (o *Options) LookPathTargetCLI() (string, error) {
+	if strings.Contains(o.TargetCLI, "/") {
+		return o.TargetCLI, checkExecutable(o.TargetCLI)
+	}
+	path, found := "", false
+	for _, arg := range o.EnvCommand {
+		if value, ok := strings.CutPrefix(arg, "PATH="); ok {
+			path, found = value, true
+		}
+	}
+	if !found {
+		path = os.Getenv("PATH")
+	}
+	for _, dir := range filepath.SplitList(path) {
+		if dir == "" {
+			dir = "."
+		}
+		file := filepath.Join(dir, o.TargetCLI)
+		if err := checkExecutable(file); err == nil {
+			return file, nil
+		}
+	}
+	return "", fmt.Errorf("%q not found in PATH %q", o.TargetCLI, path)
+}
+
+// Redacted returns a copy of Options with secrets replaced by a placeholder.
+// It is intended for printing the effective configuration.
+func (o *Options) Redacted() *Options {
+	c := *o
+	if c.DiscordToken != "" {
+		c.DiscordToken = redacted
+	}
+	if c.HTTPAPIToken != "" {
+		c.HTTPAPIToken = redacted
+	}
+	if c.IRC.SASLPassword != "" {
+		c.IRC.SASLPassword = redacted
+	}
+	if u, err := url.Parse(c.HTTPProxyURL); err == nil && u.User != nil {
+		c.HTTPProxyURL = u.Redacted()
+	}
+	return &c
+}
+
+// isEnvName returns true if name is a valid environment variable name.
+func isEnvName(name string) bool {
+	return envNamePattern.MatchString(name)
+}
+
+// checkExecutable returns an error if the file does not exist, is a directory, or is not executable.
+func checkExecutable(file string) error {
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%s is a directory", file)
+	}
+	if info.Mode()&0o111 == 0 {
+		return fmt.Errorf("%s is not executable", file)
+	}
+	return nil
+}
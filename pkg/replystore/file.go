@@ -0,0 +1,169 @@
+package replystore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sync"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// minCompactThreshold is the minimum number of journal entries appended before the journal is compacted.
+const minCompactThreshold = 1000
+
+// File is a Store persisted in an append-only journal file, keeping its contents in a Memory store.
+// The journal is compacted when it is opened and whenever it grows well beyond the capacity.
+type File struct {
+	*Memory
+	path string
+
+	mu       sync.Mutex
+	file     *os.File
+	appended int
+}
+
+// journalEntry is a line of the journal file.
+type journalEntry struct {
+	Op     string       `json:"op"` // "add", "remove" or "forget"
+	Source snowflake.ID `json:"source"`
+	Reply  Reply        `json:"reply"`
+}
+
+// OpenFile opens the journal file at path, creating it if needed, and replays it into memory.
+// At most capacity source messages are kept; if capacity is not positive, the store is unbounded.
+func OpenFile(path string, capacity int) (*File, error) {
+	f := &File{Memory: NewMemory(capacity), path: path}
+	if err := f.replay(); err != nil {
+		return nil, err
+	}
+	if err := f.compact(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Add implements Store.
+func (f *File) Add(source snowflake.ID, reply Reply) error {
+	if err := f.Memory.Add(source, reply); err != nil {
+		return err
+	}
+	return f.append(journalEntry{Op: "add", Source: source, Reply: reply})
+}
+
+// Remove implements Store.
+func (f *File) Remove(source, reply snowflake.ID) error {
+	if err := f.Memory.Remove(source, reply); err != nil {
+		return err
+	}
+	return f.append(journalEntry{Op: "remove", Source: source, Reply: Reply{MessageID: reply}})
+}
+
+// Forget implements Store.
+func (f *File) Forget(source snowflake.ID) error {
+	if err := f.Memory.Forget(source); err != nil {
+		return err
+	}
+	return f.append(journalEntry{Op: "forget", Source: source})
+}
+
+// Close implements Store by closing the journal file.
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.file.Close()
+}
+
+// replay applies the entries of the journal file to memory.
+func (f *File) replay() error {
+	file, err := os.Open(f.path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to open reply store %s: %w", f.path, err)
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return fmt.Errorf("failed to parse reply store %s at line %d: %w", f.path, line, err)
+		}
+		switch entry.Op {
+		case "add":
+			err = f.Memory.Add(entry.Source, entry.Reply)
+		case "remove":
+			err = f.Memory.Remove(entry.Source, entry.Reply.MessageID)
+		case "forget":
+			err = f.Memory.Forget(entry.Source)
+		default:
+			err = fmt.Errorf("unknown operation %q", entry.Op)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to replay reply store %s at line %d: %w", f.path, line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read reply store %s: %w", f.path, err)
+	}
+	return nil
+}
+
+// append writes an entry to the journal, compacting it if it has grown too much.
+func (f *File) append(entry journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode reply store entry: %w", err)
+	}
+	f.mu.Lock()
+	_, err = f.file.Write(append(data, '\n'))
+	f.appended++
+	needsCompaction := f.appended >= max(f.capacity*4, minCompactThreshold)
+	f.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to write reply store %s: %w", f.path, err)
+	}
+	if needsCompaction {
+		return f.compact()
+	}
+	return nil
+}
+
+// compact rewrites the journal with only the current contents and reopens it for appending.
+func (f *File) compact() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	tmp := f.path + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to create reply store %s: %w", tmp, err)
+	}
+	w := bufio.NewWriter(file)
+	encoder := json.NewEncoder(w)
+	for _, r := range f.records() {
+		for _, reply := range r.Replies {
+			if err := encoder.Encode(journalEntry{Op: "add", Source: r.Source, Reply: reply}); err != nil {
+				file.Close()
+				return fmt.Errorf("failed to write reply store %s: %w", tmp, err)
+			}
+		}
+	}
+	if err := errors.Join(w.Flush(), file.Close()); err != nil {
+		return fmt.Errorf("failed to write reply store %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return fmt.Errorf("failed to replace reply store %s: %w", f.path, err)
+	}
+	if f.file != nil {
+		_ = f.file.Close()
+	}
+	f.file, err = os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open reply store %s: %w", f.path, err)
+	}
+	f.appended = 0
+	return nil
+}
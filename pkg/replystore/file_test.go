@@ -0,0 +1,74 @@
+package replystore
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func TestFile(t *testing.T) {
+	t.Run("persists across reopen", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "replies.jsonl")
+		f, err := OpenFile(path, 0)
+		assert.NilError(t, err)
+		assert.NilError(t, f.Add(1, Reply{ChannelID: 10, MessageID: 2}))
+		assert.NilError(t, f.Add(1, Reply{ChannelID: 10, MessageID: 3}))
+		assert.NilError(t, f.Add(4, Reply{ChannelID: 10, MessageID: 5}))
+		assert.NilError(t, f.Remove(1, 2))
+		assert.NilError(t, f.Add(6, Reply{ChannelID: 10, MessageID: 7}))
+		assert.NilError(t, f.Forget(6))
+		assert.NilError(t, f.Close())
+
+		f, err = OpenFile(path, 0)
+		assert.NilError(t, err)
+		defer f.Close()
+		replies, ok := f.Replies(1)
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, replies, []Reply{{ChannelID: 10, MessageID: 3}})
+		replies, ok = f.Replies(4)
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, replies, []Reply{{ChannelID: 10, MessageID: 5}})
+		_, ok = f.Replies(6)
+		assert.Assert(t, !ok)
+
+		// Reopening compacts the journal to the current contents.
+		data, err := os.ReadFile(path)
+		assert.NilError(t, err)
+		assert.Equal(t, strings.Count(string(data), "\n"), 2)
+	})
+
+	t.Run("keeps capacity on replay", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "replies.jsonl")
+		f, err := OpenFile(path, 0)
+		assert.NilError(t, err)
+		assert.NilError(t, f.Add(1, Reply{ChannelID: 10, MessageID: 11}))
+		assert.NilError(t, f.Add(2, Reply{ChannelID: 10, MessageID: 12}))
+		assert.NilError(t, f.Close())
+
+		f, err = OpenFile(path, 1)
+		assert.NilError(t, err)
+		defer f.Close()
+		_, ok := f.Replies(1)
+		assert.Assert(t, !ok)
+		_, ok = f.Replies(2)
+		assert.Assert(t, ok)
+	})
+
+	t.Run("rejects corrupt journal", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "replies.jsonl")
+		assert.NilError(t, os.WriteFile(path, []byte("{\"op\":\"add\"}\nnot json\n"), 0o600))
+		_, err := OpenFile(path, 0)
+		assert.ErrorContains(t, err, "at line 2")
+	})
+}
+
+func TestOpen(t *testing.T) {
+	s, err := Open("", 1)
+	assert.NilError(t, err)
+	_, ok := s.(*Memory)
+	assert.Assert(t, ok)
+	assert.NilError(t, s.Close())
+}
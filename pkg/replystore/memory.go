@@ -0,0 +1,135 @@
+package replystore
+
+import (
+	"cmp"
+	"container/list"
+	"slices"
+	"sync"
+
+	"github.com/disgoorg/snowflake/v2"
+)
+
+// Memory is an in-memory Store that keeps the most recently used source messages up to its capacity.
+type Memory struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // of snowflake.ID, most recently used first
+	entries  map[snowflake.ID]*memoryEntry
+}
+
+// memoryEntry holds the replies of a source message and its position in the LRU order.
+type memoryEntry struct {
+	element *list.Element
+	replies []Reply
+}
+
+// NewMemory creates a Memory store holding at most capacity source messages.
+// If capacity is not positive, the store is unbounded.
+func NewMemory(capacity int) *Memory {
+	return &Memory{
+		capacity: capacity,
+		order:    list.New(),
+		entries:  make(map[snowflake.ID]*memoryEntry),
+	}
+}
+
+// Replies implements Store.
+func (m *Memory) Replies(source snowflake.ID) ([]Reply, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[source]
+	if !ok {
+		return nil, false
+	}
+	m.order.MoveToFront(entry.element)
+	return slices.Clone(entry.replies), true
+}
+
+// Sources implements Store.
+func (m *Memory) Sources() []snowflake.ID {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	sources := make([]snowflake.ID, 0, m.order.Len())
+	for e := m.order.Front(); e != nil; e = e.Next() {
+		sources = append(sources, e.Value.(snowflake.ID))
+	}
+	return sources
+}
+
+// Add implements Store.
+func (m *Memory) Add(source snowflake.ID, reply Reply) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[source]
+	if !ok {
+		entry = &memoryEntry{element: m.order.PushFront(source)}
+		m.entries[source] = entry
+		m.evict()
+	} else {
+		m.order.MoveToFront(entry.element)
+	}
+	cmpReply := func(r Reply, id snowflake.ID) int { return cmp.Compare(r.MessageID, id) }
+	if i, found := slices.BinarySearchFunc(entry.replies, reply.MessageID, cmpReply); !found {
+		entry.replies = slices.Insert(entry.replies, i, reply)
+	}
+	return nil
+}
+
+// Remove implements Store.
+func (m *Memory) Remove(source, reply snowflake.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[source]
+	if !ok {
+		return nil
+	}
+	entry.replies = slices.DeleteFunc(entry.replies, func(r Reply) bool { return r.MessageID == reply })
+	if len(entry.replies) == 0 {
+		m.order.Remove(entry.element)
+		delete(m.entries, source)
+	}
+	return nil
+}
+
+// Forget implements Store.
+func (m *Memory) Forget(source snowflake.ID) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.entries[source]; ok {
+		m.order.Remove(entry.element)
+		delete(m.entries, source)
+	}
+	return nil
+}
+
+// Close implements Store. It does nothing.
+func (m *Memory) Close() error {
+	return nil
+}
+
+// record is a source message with its replies.
+type record struct {
+	Source  snowflake.ID
+	Replies []Reply
+}
+
+// records returns all source messages with their replies, least recently used first.
+func (m *Memory) records() []record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	records := make([]record, 0, m.order.Len())
+	for e := m.order.Back(); e != nil; e = e.Prev() {
+		source := e.Value.(snowflake.ID)
+		records = append(records, record{Source: source, Replies: slices.Clone(m.entries[source].replies)})
+	}
+	return records
+}
+
+// evict removes the least recently used source messages exceeding the capacity.
+func (m *Memory) evict() {
+	for m.capacity > 0 && m.order.Len() > m.capacity {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(snowflake.ID))
+	}
+}
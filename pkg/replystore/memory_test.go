@@ -0,0 +1,64 @@
+package replystore
+
+import (
+	"testing"
+
+	"github.com/disgoorg/snowflake/v2"
+	"gotest.tools/v3/assert"
+)
+
+func TestMemory(t *testing.T) {
+	t.Run("records replies in order", func(t *testing.T) {
+		m := NewMemory(0)
+		assert.NilError(t, m.Add(1, Reply{ChannelID: 10, MessageID: 3}))
+		assert.NilError(t, m.Add(1, Reply{ChannelID: 10, MessageID: 2}))
+		assert.NilError(t, m.Add(1, Reply{ChannelID: 10, MessageID: 3}))
+		replies, ok := m.Replies(1)
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, replies, []Reply{{ChannelID: 10, MessageID: 2}, {ChannelID: 10, MessageID: 3}})
+
+		_, ok = m.Replies(2)
+		assert.Assert(t, !ok)
+	})
+
+	t.Run("forgets source with last reply", func(t *testing.T) {
+		m := NewMemory(0)
+		assert.NilError(t, m.Add(1, Reply{ChannelID: 10, MessageID: 2}))
+		assert.NilError(t, m.Add(1, Reply{ChannelID: 10, MessageID: 3}))
+		assert.NilError(t, m.Remove(1, 2))
+		replies, ok := m.Replies(1)
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, replies, []Reply{{ChannelID: 10, MessageID: 3}})
+		assert.NilError(t, m.Remove(1, 3))
+		_, ok = m.Replies(1)
+		assert.Assert(t, !ok)
+		assert.NilError(t, m.Remove(1, 3))
+	})
+
+	t.Run("forgets source with all replies", func(t *testing.T) {
+		m := NewMemory(0)
+		assert.NilError(t, m.Add(1, Reply{ChannelID: 10, MessageID: 2}))
+		assert.NilError(t, m.Add(1, Reply{ChannelID: 10, MessageID: 3}))
+		assert.NilError(t, m.Forget(1))
+		_, ok := m.Replies(1)
+		assert.Assert(t, !ok)
+		assert.DeepEqual(t, m.Sources(), []snowflake.ID{})
+		assert.NilError(t, m.Forget(1))
+	})
+
+	t.Run("evicts least recently used", func(t *testing.T) {
+		m := NewMemory(2)
+		for _, source := range []snowflake.ID{1, 2} {
+			assert.NilError(t, m.Add(source, Reply{ChannelID: 10, MessageID: source + 100}))
+		}
+		_, _ = m.Replies(1)
+		assert.NilError(t, m.Add(3, Reply{ChannelID: 10, MessageID: 103}))
+		_, ok := m.Replies(2)
+		assert.Assert(t, !ok)
+		_, ok = m.Replies(1)
+		assert.Assert(t, ok)
+		_, ok = m.Replies(3)
+		assert.Assert(t, ok)
+		assert.DeepEqual(t, m.Sources(), []snowflake.ID{3, 1})
+	})
+}
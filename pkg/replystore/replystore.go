@@ -0,0 +1,40 @@
+// Package replystore records which bot replies answer which source messages.
+//
+// It lets edits and deletes find the replies to update without scanning the channel history.
+package replystore
+
+import "github.com/disgoorg/snowflake/v2"
+
+// Reply identifies a bot reply to a source message.
+type Reply struct {
+	ChannelID snowflake.ID `json:"channel_id"`
+	MessageID snowflake.ID `json:"message_id"`
+}
+
+// Store records the replies sent for each source message.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// Replies returns the replies recorded for the source message in ascending order of message ID,
+	// and whether the source message is known to the store.
+	Replies(source snowflake.ID) ([]Reply, bool)
+	// Sources returns the source messages known to the store, most recently used first.
+	Sources() []snowflake.ID
+	// Add records a reply to the source message.
+	Add(source snowflake.ID, reply Reply) error
+	// Remove forgets a reply to the source message. The source message is forgotten with its last reply.
+	Remove(source, reply snowflake.ID) error
+	// Forget forgets the source message with all of its replies, e.g. when they may be incomplete,
+	// so that they are found by scanning the channel again.
+	Forget(source snowflake.ID) error
+	// Close releases the resources held by the store.
+	Close() error
+}
+
+// Open returns a File store persisted at path, or a Memory store if path is empty.
+// Either store keeps at most capacity source messages in memory.
+func Open(path string, capacity int) (Store, error) {
+	if path == "" {
+		return NewMemory(capacity), nil
+	}
+	return OpenFile(path, capacity)
+}
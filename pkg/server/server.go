@@ -0,0 +1,119 @@
+// Package server provides the optional HTTP server exposing health and readiness endpoints.
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// shutdownTimeout is the duration to wait for active connections when the server shuts down.
+const shutdownTimeout = 5 * time.Second
+
+// Check is a function that returns an error if the checked component is not ready.
+type Check func(context.Context) error
+
+// Server is an HTTP server serving `/healthz`, `/readyz` and any additional handlers.
+type Server struct {
+	addr   string
+	mux    *http.ServeMux
+	mu     sync.Mutex
+	names  []string
+	checks []Check
+}
+
+// New creates a new Server listening on addr with the health and readiness endpoints registered.
+func New(addr string) *Server {
+	s := &Server{addr: addr, mux: http.NewServeMux()}
+	s.mux.HandleFunc("GET /healthz", s.healthz)
+	s.mux.HandleFunc("GET /readyz", s.readyz)
+	return s
+}
+
+// Handle registers the handler for the given pattern.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+// AddReadinessCheck registers a check that must succeed for `/readyz` to report ready.
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.names = append(s.names, name)
+	s.checks = append(s.checks, check)
+}
+
+// ServeHTTP dispatches the request to the registered handlers.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+// ListenAndServe listens on the address and serves requests until ctx is done.
+// It shuts down gracefully when ctx is done and returns nil in that case.
+func (s *Server) ListenAndServe(ctx context.Context) error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+	return s.Serve(ctx, listener)
+}
+
+// Serve serves requests on the listener until ctx is done.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) error {
+	srv := &http.Server{
+		Handler:           s,
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to shut down HTTP server", slog.Any("err", err))
+		}
+	}()
+	slog.Info("HTTP server listening", slog.String("addr", listener.Addr().String()))
+	if err := srv.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve HTTP: %w", err)
+	}
+	return nil
+}
+
+// healthz reports that the process is alive.
+func (s *Server) healthz(w http.ResponseWriter, _ *http.Request) {
+	writeText(w, http.StatusOK, "ok\n")
+}
+
+// readyz runs all readiness checks and reports every failure.
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	names, checks := s.names, s.checks
+	s.mu.Unlock()
+	var b strings.Builder
+	status := http.StatusOK
+	for i, check := range checks {
+		if err := check(r.Context()); err != nil {
+			status = http.StatusServiceUnavailable
+			fmt.Fprintf(&b, "%s: %v\n", names[i], err)
+		} else {
+			fmt.Fprintf(&b, "%s: ok\n", names[i])
+		}
+	}
+	writeText(w, status, b.String())
+}
+
+// writeText writes a plain text response with the given status code.
+func writeText(w http.ResponseWriter, status int, text string) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(status)
+	if _, err := w.Write([]byte(text)); err != nil {
+		slog.Error("Failed to write HTTP response", slog.Any("err", err))
+	}
+}
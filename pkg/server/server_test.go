@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"gotest.tools/v3/assert"
+)
+
+func get(t *testing.T, s *Server, path string) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, http.NoBody))
+	body, err := io.ReadAll(w.Result().Body)
+	assert.NilError(t, err)
+	return w.Code, string(body)
+}
+
+func TestHealthz(t *testing.T) {
+	s := New("")
+	s.AddReadinessCheck("failing", func(context.Context) error { return errors.New("down") })
+	code, body := get(t, s, "/healthz")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, body, "ok\n")
+}
+
+func TestReadyz(t *testing.T) {
+	t.Run("ready", func(t *testing.T) {
+		s := New("")
+		s.AddReadinessCheck("gateway", func(context.Context) error { return nil })
+		code, body := get(t, s, "/readyz")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, body, "gateway: ok\n")
+	})
+
+	t.Run("not ready", func(t *testing.T) {
+		s := New("")
+		s.AddReadinessCheck("gateway", func(context.Context) error { return nil })
+		s.AddReadinessCheck("target", func(context.Context) error { return errors.New("not found") })
+		code, body := get(t, s, "/readyz")
+		assert.Equal(t, code, http.StatusServiceUnavailable)
+		assert.Equal(t, body, "gateway: ok\ntarget: not found\n")
+	})
+}
+
+func TestServe(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s := New("127.0.0.1:0")
+	srv := httptest.NewUnstartedServer(nil)
+	listener := srv.Listener
+	done := make(chan error)
+	go func() { done <- s.Serve(ctx, listener) }()
+	resp, err := http.Get("http://" + listener.Addr().String() + "/healthz")
+	assert.NilError(t, err)
+	assert.Equal(t, resp.StatusCode, http.StatusOK)
+	assert.NilError(t, resp.Body.Close())
+	cancel()
+	assert.NilError(t, <-done)
+}
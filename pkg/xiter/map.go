@@ -15,3 +15,14 @@ func Map[T, U any](seq iter.Seq[T], f func(T) U) iter.Seq[U] {
 		}
 	}
 }
+
+// MapValues returns a new iter.Seq2[K, U] that yields k and f(v) for each k, v in seq.
+func MapValues[K, V, U any](seq iter.Seq2[K, V], f func(V) U) iter.Seq2[K, U] {
+	return func(yield func(K, U) bool) {
+		for k, v := range seq {
+			if !yield(k, f(v)) {
+				return
+			}
+		}
+	}
+}
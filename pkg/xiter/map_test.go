@@ -25,3 +25,17 @@ func TestMap_Empty(t *testing.T) {
 	got := slices.Collect(mapped)
 	assert.Equal(t, len(got), 0)
 }
+
+func TestMapValues(t *testing.T) {
+	seq := func(yield func(int, int) bool) {
+		_ = yield(2, 20) && yield(0, 0) && yield(1, 10)
+	}
+	var keys []int
+	var values []string
+	for k, v := range MapValues(seq, func(n int) string { return string(rune('A' + n/10)) }) {
+		keys = append(keys, k)
+		values = append(values, v)
+	}
+	assert.DeepEqual(t, keys, []int{2, 0, 1})
+	assert.DeepEqual(t, values, []string{"C", "A", "B"})
+}